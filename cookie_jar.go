@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/gor/proto"
+)
+
+// Sessions without requests for this time are forgotten
+const cookieSessionTTL = 30 * time.Minute
+
+type cookieSession struct {
+	cookies map[string][]byte
+	seen    time.Time
+}
+
+// CookieJar keeps cookies set by replayed server, separately for each original user session,
+// and substitutes them in the following requests of the same session.
+//
+// Session key can be `cookie:<name>`, `header:<name>` (original value used as key), or `ip` (client address).
+// If strip enabled, original cookies not set by replayed server are removed from requests.
+type CookieJar struct {
+	source string
+	name   []byte
+	strip  bool
+
+	mu        sync.Mutex
+	sessions  map[string]*cookieSession
+	lastSweep time.Time
+}
+
+// NewCookieJar constructor for CookieJar, fails if session key can't be parsed
+func NewCookieJar(sessionKey string, strip bool) *CookieJar {
+	j := new(CookieJar)
+	j.strip = strip
+	j.sessions = make(map[string]*cookieSession)
+	j.lastSweep = time.Now()
+
+	if sessionKey == "ip" {
+		j.source = sessionKey
+		return j
+	}
+
+	kv := strings.SplitN(sessionKey, ":", 2)
+	if len(kv) != 2 || kv[1] == "" || (kv[0] != "cookie" && kv[0] != "header") {
+		log.Fatal("Cookie session key should be `cookie:<name>`, `header:<name>` or `ip`, got: ", sessionKey)
+	}
+
+	j.source, j.name = kv[0], []byte(kv[1])
+
+	return j
+}
+
+// Session returns session key of request payload, empty if request has no session
+func (j *CookieJar) Session(request []byte) string {
+	body := payloadBody(request)
+	if proto.MIMEHeadersEndPos(body) == -1 {
+		return ""
+	}
+
+	switch j.source {
+	case "ip":
+		src := string(payloadMetaValue(payloadMeta(request), metaSrc))
+		if host, _, err := net.SplitHostPort(src); err == nil {
+			return host
+		}
+		return src
+	case "header":
+		return string(proto.Header(body, j.name))
+	case "cookie":
+		value, _, _ := cookieValue(proto.Header(body, []byte("Cookie")), j.name)
+		return string(value)
+	}
+
+	return ""
+}
+
+// Apply replaces request cookies with the ones stored for the session
+func (j *CookieJar) Apply(session string, payload []byte) []byte {
+	if session == "" || proto.MIMEHeadersEndPos(payload) == -1 {
+		return payload
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.sweep()
+
+	s, ok := j.sessions[session]
+	if !ok {
+		s = &cookieSession{cookies: make(map[string][]byte)}
+		j.sessions[session] = s
+	}
+	s.seen = time.Now()
+
+	var cookies [][]byte
+	used := make(map[string]bool)
+
+	for _, pair := range bytes.Split(proto.Header(payload, []byte("Cookie")), []byte(";")) {
+		pair = bytes.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+
+		name := pair
+		if eq := bytes.IndexByte(pair, '='); eq != -1 {
+			name = bytes.TrimSpace(pair[:eq])
+		}
+
+		if value, ok := s.cookies[string(name)]; ok {
+			used[string(name)] = true
+			cookies = append(cookies, cookiePair(name, value))
+		} else if !j.strip {
+			cookies = append(cookies, pair)
+		}
+	}
+
+	var added []string
+	for name := range s.cookies {
+		if !used[name] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+
+	for _, name := range added {
+		cookies = append(cookies, cookiePair([]byte(name), s.cookies[name]))
+	}
+
+	if len(cookies) == 0 {
+		return proto.DeleteHeader(payload, []byte("Cookie"))
+	}
+
+	return proto.SetHeader(payload, []byte("Cookie"), bytes.Join(cookies, []byte("; ")))
+}
+
+// Update stores cookies from `Set-Cookie` headers of replayed response
+func (j *CookieJar) Update(session string, response []byte) {
+	if session == "" || proto.MIMEHeadersEndPos(response) == -1 {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s, ok := j.sessions[session]
+	if !ok {
+		s = &cookieSession{cookies: make(map[string][]byte), seen: time.Now()}
+		j.sessions[session] = s
+	}
+
+	for _, header := range proto.Headers(response, []byte("Set-Cookie")) {
+		name, value, expired := parseSetCookie(header)
+		if len(name) == 0 {
+			continue
+		}
+
+		if expired {
+			delete(s.cookies, string(name))
+		} else {
+			s.cookies[string(name)] = value
+		}
+	}
+}
+
+// sweep removes idle sessions. Should be called under lock.
+func (j *CookieJar) sweep() {
+	now := time.Now()
+	if now.Sub(j.lastSweep) < cookieSessionTTL/10 {
+		return
+	}
+	j.lastSweep = now
+
+	for key, s := range j.sessions {
+		if now.Sub(s.seen) > cookieSessionTTL {
+			delete(j.sessions, key)
+		}
+	}
+}
+
+func (j *CookieJar) String() string {
+	return fmt.Sprintf("Cookie jar with %d sessions", len(j.sessions))
+}
+
+// parseSetCookie returns cookie name and value, and tells if server asked to remove it
+func parseSetCookie(header []byte) (name, value []byte, expired bool) {
+	attrs := bytes.Split(header, []byte(";"))
+
+	kv := bytes.SplitN(attrs[0], []byte("="), 2)
+	if len(kv) != 2 {
+		return
+	}
+
+	name = bytes.TrimSpace(kv[0])
+	value = append([]byte(nil), bytes.TrimSpace(kv[1])...)
+
+	for _, attr := range attrs[1:] {
+		kv := bytes.SplitN(bytes.TrimSpace(attr), []byte("="), 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch {
+		case bytes.EqualFold(kv[0], []byte("Max-Age")):
+			if age, err := strconv.Atoi(string(kv[1])); err == nil && age <= 0 {
+				expired = true
+			}
+		case bytes.EqualFold(kv[0], []byte("Expires")):
+			if t, err := time.Parse(time.RFC1123, string(kv[1])); err == nil && t.Before(time.Now()) {
+				expired = true
+			}
+		}
+	}
+
+	return
+}
+
+func cookiePair(name, value []byte) []byte {
+	return append(append(append([]byte(nil), name...), '='), value...)
+}
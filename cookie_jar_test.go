@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCookieJar(t *testing.T) {
+	jar := NewCookieJar("cookie:sid", false)
+
+	request := []byte("1 a 1\nGET / HTTP/1.1\r\nCookie: sid=prod1; lang=en\r\n\r\n")
+	session := jar.Session(request)
+
+	if session != "prod1" {
+		t.Fatal("Wrong session", session)
+	}
+
+	if body := jar.Apply(session, payloadBody(request)); string(body) != "GET / HTTP/1.1\r\nCookie: sid=prod1; lang=en\r\n\r\n" {
+		t.Errorf("Request should not change until server sets cookies: %q", body)
+	}
+
+	jar.Update(session, []byte("HTTP/1.1 200 OK\r\nSet-Cookie: sid=stage1; Path=/; HttpOnly\r\nset-cookie: csrf=x\r\n\r\n"))
+
+	if body := jar.Apply(session, []byte("GET / HTTP/1.1\r\nCookie: sid=prod1; lang=en\r\n\r\n")); string(body) != "GET / HTTP/1.1\r\nCookie: sid=stage1; lang=en; csrf=x\r\n\r\n" {
+		t.Errorf("Cookies should be replaced: %q", body)
+	}
+
+	if body := jar.Apply("prod2", []byte("GET / HTTP/1.1\r\nCookie: sid=prod2\r\n\r\n")); string(body) != "GET / HTTP/1.1\r\nCookie: sid=prod2\r\n\r\n" {
+		t.Errorf("Other sessions should not be affected: %q", body)
+	}
+
+	jar.Update(session, []byte("HTTP/1.1 200 OK\r\nSet-Cookie: csrf=; Max-Age=0\r\n\r\n"))
+
+	if body := jar.Apply(session, []byte("GET / HTTP/1.1\r\nCookie: sid=prod1\r\n\r\n")); string(body) != "GET / HTTP/1.1\r\nCookie: sid=stage1\r\n\r\n" {
+		t.Errorf("Expired cookie should be removed: %q", body)
+	}
+}
+
+func TestCookieJarStrip(t *testing.T) {
+	jar := NewCookieJar("ip", true)
+
+	request := []byte("1 a 1 src=10.0.0.1:5000\nGET / HTTP/1.1\r\nCookie: sid=prod1\r\nHost: a\r\n\r\n")
+	session := jar.Session(request)
+
+	if session != "10.0.0.1" {
+		t.Fatal("Wrong session", session)
+	}
+
+	if body := jar.Apply(session, payloadBody(request)); string(body) != "GET / HTTP/1.1\r\nHost: a\r\n\r\n" {
+		t.Errorf("Original cookies should be stripped: %q", body)
+	}
+
+	jar.Update(session, []byte("HTTP/1.1 200 OK\r\nSet-Cookie: sid=stage1\r\n\r\n"))
+
+	if body := jar.Apply(session, []byte("GET / HTTP/1.1\r\nCookie: sid=prod1; lang=en\r\n\r\n")); string(body) != "GET / HTTP/1.1\r\nCookie: sid=stage1\r\n\r\n" {
+		t.Errorf("Original cookies should be mapped or stripped: %q", body)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// CorrelationConfig holds correlation store limits
+type CorrelationConfig struct {
+	// How long to keep request and its responses, store disabled if 0
+	ttl time.Duration
+	// Maximum size of stored payloads, oldest entries evicted when reached
+	sizeLimit DataSize
+}
+
+// Correlation holds request and all its responses
+type Correlation struct {
+	Request  []byte
+	Response []byte
+	// Replayed responses by target, see metaTarget
+	Replayed map[string][]byte
+}
+
+type correlationEntry struct {
+	Correlation
+
+	id      string
+	created time.Time
+	size    int
+	elem    *list.Element
+}
+
+// CorrelationStore keeps request, original response and replayed responses by request id,
+// so pipeline stages can access them together. Entries are evicted after TTL, or when size limit reached.
+type CorrelationStore struct {
+	mu     sync.Mutex
+	config *CorrelationConfig
+
+	entries map[string]*correlationEntry
+	// Entries ordered by creation time, used for eviction
+	order *list.List
+	size  int64
+}
+
+// Correlations is shared correlation store, nil if disabled
+var Correlations *CorrelationStore
+
+// NewCorrelationStore constructor for CorrelationStore
+func NewCorrelationStore(config *CorrelationConfig) *CorrelationStore {
+	s := new(CorrelationStore)
+	s.config = config
+	s.entries = make(map[string]*correlationEntry)
+	s.order = list.New()
+
+	go s.expireTicker()
+
+	return s
+}
+
+// Add stores payload of any type. Payloads are copied.
+func (s *CorrelationStore) Add(payload []byte) {
+	meta := payloadMeta(payload)
+	if len(meta) < 2 || len(meta[0]) == 0 {
+		return
+	}
+
+	body := append([]byte(nil), payloadBody(payload)...)
+	id := string(meta[1])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		e = &correlationEntry{id: id, created: time.Now()}
+		e.elem = s.order.PushBack(e)
+		s.entries[id] = e
+	}
+
+	var old []byte
+
+	switch meta[0][0] {
+	case RequestPayload:
+		old, e.Request = e.Request, body
+	case ResponsePayload:
+		old, e.Response = e.Response, body
+	case ReplayedResponsePayload:
+		if e.Replayed == nil {
+			e.Replayed = make(map[string][]byte)
+		}
+		target := string(payloadMetaValue(meta, metaTarget))
+		old, e.Replayed[target] = e.Replayed[target], body
+	default:
+		return
+	}
+
+	e.size += len(body) - len(old)
+	s.size += int64(len(body) - len(old))
+
+	s.evict()
+}
+
+// Get returns copy of stored correlation
+func (s *CorrelationStore) Get(id []byte) (c Correlation, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[string(id)]
+	if !ok {
+		return
+	}
+
+	c = e.Correlation
+	if e.Replayed != nil {
+		c.Replayed = make(map[string][]byte, len(e.Replayed))
+		for k, v := range e.Replayed {
+			c.Replayed[k] = v
+		}
+	}
+
+	return c, true
+}
+
+// Len returns number of stored entries
+func (s *CorrelationStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// evict removes expired entries, and oldest entries while size limit exceeded. Should be called under lock.
+func (s *CorrelationStore) evict() {
+	now := time.Now()
+
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		e := front.Value.(*correlationEntry)
+
+		if now.Sub(e.created) < s.config.ttl && (s.config.sizeLimit == 0 || s.size <= int64(s.config.sizeLimit)) {
+			return
+		}
+
+		s.order.Remove(front)
+		delete(s.entries, e.id)
+		s.size -= int64(e.size)
+	}
+}
+
+func (s *CorrelationStore) expireTicker() {
+	for range time.Tick(s.config.ttl / 10) {
+		s.mu.Lock()
+		s.evict()
+		s.mu.Unlock()
+	}
+}
+
+// correlationReader adds all payloads read from plugin to the store
+type correlationReader struct {
+	io.Reader
+	store *CorrelationStore
+}
+
+func (r *correlationReader) Read(data []byte) (n int, err error) {
+	n, err = r.Reader.Read(data)
+
+	if n > 0 {
+		r.store.Add(data[:n])
+	}
+
+	return
+}
+
+func (r *correlationReader) String() string {
+	return fmt.Sprint(r.Reader)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCorrelationStore(t *testing.T) {
+	s := NewCorrelationStore(&CorrelationConfig{ttl: time.Minute})
+
+	s.Add([]byte("1 a 1\nGET / HTTP/1.1\r\n\r\n"))
+	s.Add([]byte("2 a 1\nHTTP/1.1 200 OK\r\n\r\n"))
+	s.Add([]byte("3 a 1 target=staging\nHTTP/1.1 500 Internal Server Error\r\n\r\n"))
+	s.Add([]byte("3 a 1 target=dev\nHTTP/1.1 404 Not Found\r\n\r\n"))
+
+	c, ok := s.Get([]byte("a"))
+	if !ok {
+		t.Fatal("Should find correlation")
+	}
+
+	if !bytes.Equal(c.Request, []byte("GET / HTTP/1.1\r\n\r\n")) || !bytes.Equal(c.Response, []byte("HTTP/1.1 200 OK\r\n\r\n")) {
+		t.Errorf("Wrong request or response: %q %q", c.Request, c.Response)
+	}
+
+	if len(c.Replayed) != 2 || !bytes.HasPrefix(c.Replayed["staging"], []byte("HTTP/1.1 500")) || !bytes.HasPrefix(c.Replayed["dev"], []byte("HTTP/1.1 404")) {
+		t.Error("Should keep replayed responses by target", c.Replayed)
+	}
+
+	if _, ok := s.Get([]byte("b")); ok {
+		t.Error("Should not find unknown id")
+	}
+}
+
+func TestCorrelationStoreEviction(t *testing.T) {
+	s := NewCorrelationStore(&CorrelationConfig{ttl: 20 * time.Millisecond, sizeLimit: 50})
+
+	s.Add([]byte("1 a 1\n0123456789012345678901234567890123456789"))
+	s.Add([]byte("1 b 1\n0123456789012345678901234567890123456789"))
+
+	if _, ok := s.Get([]byte("a")); ok || s.Len() != 1 {
+		t.Error("Oldest entry should be evicted when size limit reached")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if s.Len() != 0 {
+		t.Error("Entries should expire after TTL")
+	}
+}
+
+func TestCorrelationMiddlewareMessage(t *testing.T) {
+	Correlations = NewCorrelationStore(&CorrelationConfig{ttl: time.Minute})
+	defer func() { Correlations = nil }()
+
+	Correlations.Add([]byte("1 a 1\nGET /a HTTP/1.1\r\n\r\n"))
+	Correlations.Add([]byte("2 a 1\nHTTP/1.1 200 OK\r\n\r\n"))
+
+	msg := newMiddlewareMessage([]byte("3 a 1\nHTTP/1.1 500 Internal Server Error\r\n\r\n"))
+
+	if msg.Correlation == nil || msg.Correlation.Request.Path != "/a" || msg.Correlation.Response.Status != 200 {
+		t.Error("Replayed response should include request and original response", msg.Correlation)
+	}
+}
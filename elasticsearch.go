@@ -48,6 +48,10 @@ type ESRequestResponse struct {
 	RespSetCookie        []byte `json:"Resp_Set-Cookie,omitempty"`
 	Rtt                  int64  `json:"RTT"`
 	Timestamp            time.Time
+
+	// Original response, available if correlation store enabled and original response already received
+	OrigRespStatus        []byte `json:"Orig_Resp_Status,omitempty"`
+	OrigRespContentLength []byte `json:"Orig_Resp_Content-Length,omitempty"`
 }
 
 // Parse ElasticSearch URI
@@ -119,30 +123,49 @@ func (p *ESPlugin) ResponseAnalyze(req, resp []byte, start, stop time.Time) {
 	t := time.Now()
 	rtt := p.RttDurationToMs(stop.Sub(start))
 
+	var original []byte
+	meta := payloadMeta(req)
+
+	if store := Correlations; store != nil && len(meta) > 1 {
+		if c, ok := store.Get(meta[1]); ok {
+			original = c.Response
+		}
+	}
+
+	request := proto.NewMessage(payloadBody(req))
+	response := proto.NewMessage(resp)
+
 	esResp := ESRequestResponse{
-		ReqUrl:               proto.Path(req),
-		ReqMethod:            proto.Method(req),
-		ReqUserAgent:         proto.Header(req, []byte("User-Agent")),
-		ReqAcceptLanguage:    proto.Header(req, []byte("Accept-Language")),
-		ReqAccept:            proto.Header(req, []byte("Accept")),
-		ReqAcceptEncoding:    proto.Header(req, []byte("Accept-Encoding")),
-		ReqIfModifiedSince:   proto.Header(req, []byte("If-Modified-Since")),
-		ReqConnection:        proto.Header(req, []byte("Connection")),
-		ReqCookies:           proto.Header(req, []byte("Cookie")),
-		RespStatus:           proto.Status(resp),
-		RespStatusCode:       proto.Status(resp),
-		RespProto:            proto.Method(resp),
-		RespContentLength:    proto.Header(resp, []byte("Content-Length")),
-		RespContentType:      proto.Header(resp, []byte("Content-Type")),
-		RespTransferEncoding: proto.Header(resp, []byte("Transfer-Encoding")),
-		RespContentEncoding:  proto.Header(resp, []byte("Content-Encoding")),
-		RespExpires:          proto.Header(resp, []byte("Expires")),
-		RespCacheControl:     proto.Header(resp, []byte("Cache-Control")),
-		RespVary:             proto.Header(resp, []byte("Vary")),
-		RespSetCookie:        proto.Header(resp, []byte("Set-Cookie")),
+		ReqUrl:               request.Path(),
+		ReqMethod:            request.Method(),
+		ReqUserAgent:         request.Header([]byte("User-Agent")),
+		ReqAcceptLanguage:    request.Header([]byte("Accept-Language")),
+		ReqAccept:            request.Header([]byte("Accept")),
+		ReqAcceptEncoding:    request.Header([]byte("Accept-Encoding")),
+		ReqIfModifiedSince:   request.Header([]byte("If-Modified-Since")),
+		ReqConnection:        request.Header([]byte("Connection")),
+		ReqCookies:           request.Header([]byte("Cookie")),
+		RespStatus:           response.Status(),
+		RespStatusCode:       response.Status(),
+		RespProto:            response.Proto(),
+		RespContentLength:    response.Header([]byte("Content-Length")),
+		RespContentType:      response.Header([]byte("Content-Type")),
+		RespTransferEncoding: response.Header([]byte("Transfer-Encoding")),
+		RespContentEncoding:  response.Header([]byte("Content-Encoding")),
+		RespExpires:          response.Header([]byte("Expires")),
+		RespCacheControl:     response.Header([]byte("Cache-Control")),
+		RespVary:             response.Header([]byte("Vary")),
+		RespSetCookie:        response.Header([]byte("Set-Cookie")),
 		Rtt:                  rtt,
 		Timestamp:            t,
 	}
+
+	if len(original) > 0 {
+		orig := proto.NewMessage(original)
+		esResp.OrigRespStatus = orig.Status()
+		esResp.OrigRespContentLength = orig.Header([]byte("Content-Length"))
+	}
+
 	j, err := json.Marshal(&esResp)
 	if err != nil {
 		log.Println(err)
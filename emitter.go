@@ -8,30 +8,77 @@ import (
 
 // Start initialize loop for sending data from inputs to outputs
 func Start(stop chan int) {
-	if Settings.middleware != "" {
-		middleware := NewMiddleware(Settings.middleware)
+	var middlewares []middlewareStage
+	processors := Plugins.Processors
 
-		for _, in := range Plugins.Inputs {
-			middleware.ReadFrom(in)
+	inputs := make([]io.Reader, len(Plugins.Inputs))
+	copy(inputs, Plugins.Inputs)
+
+	// Outputs which return replayed responses
+	var responses []io.Reader
+	for _, out := range Plugins.Outputs {
+		if r, ok := out.(io.Reader); ok {
+			responses = append(responses, r)
+		}
+	}
+
+	if store := Correlations; store != nil {
+		for i, in := range inputs {
+			inputs[i] = &correlationReader{in, store}
+		}
+
+		for i, r := range responses {
+			responses[i] = &correlationReader{r, store}
 		}
+	}
+
+	if len(Settings.middleware) > 0 {
+		// Middlewares chained in order: inputs and replayed responses go to the first one,
+		// output of each middleware is input of the next one
+		var src io.Reader
+
+		for i, command := range Settings.middleware {
+			middleware := newMiddlewareStage(command, &Settings.middlewareConfig)
+			middlewares = append(middlewares, middleware)
+
+			if i > 0 {
+				middleware.ReadFromPlugin(src)
+				src = middleware
+				continue
+			}
+
+			for _, in := range inputs {
+				middleware.ReadFromPlugin(in)
+			}
 
-		// We going only to read responses, so using same ReadFrom method
-		for _, out := range Plugins.Outputs {
-			if r, ok := out.(io.Reader); ok {
-				middleware.ReadFrom(r)
+			// We going only to read responses, so using same ReadFromPlugin method
+			for _, r := range responses {
+				middleware.ReadFromPlugin(r)
 			}
+
+			src = middleware
 		}
 
-		go CopyMulty(middleware, Plugins.Outputs...)
+		go CopyMulty(src, Plugins.Outputs...)
 	} else {
-		for _, in := range Plugins.Inputs {
+		for _, in := range inputs {
 			go CopyMulty(in, Plugins.Outputs...)
 		}
+
+		// Processors, correlation store and response filters should see replayed responses as well
+		if len(processors) > 0 || Correlations != nil || ResponseFilters != nil {
+			for _, r := range responses {
+				go CopyMulty(r, Plugins.Outputs...)
+			}
+		}
 	}
 
 	for {
 		select {
 		case <-stop:
+			for _, m := range middlewares {
+				m.Close()
+			}
 			return
 		case <-time.After(time.Second):
 		}
@@ -43,6 +90,9 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 	buf := make([]byte, 5*1024*1024)
 	wIndex := 0
 	modifier := NewHTTPModifier(&Settings.modifierConfig)
+	processors := Plugins.Processors
+	scrubber := NewScrubber(&Settings.scrubberConfig)
+	responseFilter := ResponseFilters
 
 	for {
 		nr, er := src.Read(buf)
@@ -60,9 +110,12 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 			}
 
 			if modifier != nil && isRequestPayload(payload) {
+				if !modifier.FilterMeta(payloadMeta(payload)) {
+					continue
+				}
+
 				headSize := bytes.IndexByte(payload, '\n') + 1
 				body := payload[headSize:]
-				originalBodyLen := len(body)
 				body = modifier.Rewrite(body)
 
 				// If modifier tells to skip request
@@ -70,30 +123,31 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 					continue
 				}
 
-				if originalBodyLen != len(body) {
-					payload = append(payload[:headSize], body...)
-				}
+				// Rewritten payload can have the same length as original, so always copy it back.
+				// Unmodified payload returned as is, and copied onto itself.
+				payload = append(payload[:headSize], body...)
 
 				if Settings.debug {
 					Debug("[EMITTER] Rewrittern input:", len(payload), "First 500 bytes:", string(payload[0:_maxN]))
 				}
 			}
 
-			if Settings.splitOutput {
-				// Simple round robin
-				writers[wIndex].Write(payload)
-
-				wIndex++
+			payloads := [][]byte{payload}
+			if responseFilter != nil {
+				// Request is held until its response is seen
+				payloads = responseFilter.Filter(payload)
+			}
 
-				if wIndex >= len(writers) {
-					wIndex = 0
+			for _, payload := range payloads {
+				if len(processors) == 0 {
+					wIndex = writePayload(scrubPayload(scrubber, payload), wIndex, writers)
+					continue
 				}
-			} else {
-				for _, dst := range writers {
-					dst.Write(payload)
+
+				for _, p := range processPayload(processors, payload) {
+					wIndex = writePayload(scrubPayload(scrubber, p), wIndex, writers)
 				}
 			}
-
 		}
 		if er == io.EOF {
 			break
@@ -105,3 +159,33 @@ func CopyMulty(src io.Reader, writers ...io.Writer) (err error) {
 	}
 	return err
 }
+
+// scrubPayload removes personal data from payload right before it reaches outputs
+func scrubPayload(scrubber *Scrubber, payload []byte) []byte {
+	if scrubber == nil {
+		return payload
+	}
+
+	return scrubber.Scrub(payload)
+}
+
+// writePayload sends payload to all writers, or to the next one if `--split-output` enabled
+// Returns index of the next writer for round robin
+func writePayload(payload []byte, wIndex int, writers []io.Writer) int {
+	if Settings.splitOutput {
+		// Simple round robin
+		writers[wIndex].Write(payload)
+
+		wIndex++
+
+		if wIndex >= len(writers) {
+			wIndex = 0
+		}
+	} else {
+		for _, dst := range writers {
+			dst.Write(payload)
+		}
+	}
+
+	return wIndex
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"io"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -97,3 +99,82 @@ func BenchmarkEmitter(b *testing.B) {
 	wg.Wait()
 	close(quit)
 }
+
+// emitThroughModifier passes payloads through CopyMulty with given modifier config, and returns emitted payloads
+func emitThroughModifier(config HTTPModifierConfig, payloads ...string) (received []string) {
+	original := Settings.modifierConfig
+	Settings.modifierConfig = config
+	defer func() { Settings.modifierConfig = original }()
+
+	output := NewTestOutput(func(data []byte) {
+		received = append(received, string(data))
+	})
+
+	CopyMulty(&testPayloadReader{payloads}, output)
+
+	return
+}
+
+func TestEmitterRewriteSameLength(t *testing.T) {
+	config := HTTPModifierConfig{}
+	config.headers.Set("X-A: 2")
+	config.params.Set("a=2")
+
+	received := emitThroughModifier(config, "1 a 1\nGET /?a=1 HTTP/1.1\r\nX-A: 1\r\n\r\n")
+
+	if expected := "1 a 1\nGET /?a=2 HTTP/1.1\r\nX-A: 2\r\n\r\n"; len(received) != 1 || received[0] != expected {
+		t.Errorf("Rewrite of the same length should be applied: %q", received)
+	}
+}
+
+func TestEmitterRewriteBodySameLength(t *testing.T) {
+	jsonConfig := HTTPModifierConfig{}
+	jsonConfig.jsonFields.Set("email=x@y.com")
+
+	formConfig := HTTPModifierConfig{}
+	formConfig.formFields.Set("a=2")
+
+	rewriteConfig := HTTPModifierConfig{}
+	rewriteConfig.bodyRewrite.Set("secret:public")
+
+	tests := []struct {
+		name     string
+		config   HTTPModifierConfig
+		body     string
+		expected string
+	}{
+		{"set-json", jsonConfig, `{"email":"a@b.com"}`, `{"email":"x@y.com"}`},
+		{"set-form", formConfig, "a=1&b=1", "a=2&b=1"},
+		{"rewrite-body", rewriteConfig, "secret", "public"},
+	}
+
+	for _, tc := range tests {
+		head := "1 a 1\nPOST / HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(tc.body)) + "\r\n\r\n"
+		if tc.name == "set-form" {
+			head = strings.Replace(head, "application/json", "application/x-www-form-urlencoded", 1)
+		}
+
+		received := emitThroughModifier(tc.config, head+tc.body)
+
+		if len(received) != 1 || received[0] != head+tc.expected {
+			t.Errorf("%s: rewrite of the same length should be applied: %q", tc.name, received)
+		}
+	}
+}
+
+func TestEmitterReplaceFiles(t *testing.T) {
+	config := HTTPModifierConfig{replaceFiles: 4}
+
+	body := "--xyz\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\nSECRETFILECONTENT\r\n--xyz--\r\n"
+	payload := "1 a 1\nPOST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=xyz\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	received := emitThroughModifier(config, payload)
+
+	if len(received) != 1 || len(received[0]) != len(payload) {
+		t.Fatalf("Payload with placeholder should have the same size: %q", received)
+	}
+
+	if strings.Contains(received[0], "SECRETFILECONTENT") {
+		t.Errorf("File should be replaced with placeholder: %q", received[0])
+	}
+}
@@ -0,0 +1,21 @@
+#!/usr/bin/env python
+# Example of middleware using JSON protocol:
+#   gor --input-raw :80 --middleware "./examples/middleware/json_modifier.py" --middleware-protocol json --output-http staging.com
+#
+# Adds header to all requests, and drops requests to /admin
+import json
+import sys
+
+for line in sys.stdin:
+    msg = json.loads(line)
+
+    if msg["type"] == "request" and "http" in msg:
+        if msg["http"]["path"].startswith("/admin"):
+            msg["action"] = "drop"
+        else:
+            msg["http"]["headers"].append(["X-Replayed-By", "gor"])
+
+    sys.stdout.write(json.dumps(msg) + "\n")
+    sys.stdout.flush()
+
+    sys.stderr.write("[DEBUG][MIDDLEWARE] %s\n" % line.strip())
@@ -0,0 +1,47 @@
+-- Lua version of token_modifier.go, runs inside Gor process:
+--   gor --input-raw :80 --middleware-script ./examples/middleware/token_modifier.lua --output-http staging.com
+--
+-- Stores `originalToken -> replayedToken` aliases, and rewrites requests using original token to use replayed alias.
+
+-- requestID -> originalToken
+local original_tokens = {}
+
+-- originalToken -> replayedToken
+local token_aliases = {}
+
+function on_request(req)
+    if req:path() == "/token" then
+        original_tokens[req:id()] = ""
+        gor.log("Found token request:", req:id())
+    else
+        local alias = token_aliases[req:param("token")]
+
+        if alias then
+            req:set_param("token", alias)
+        end
+    end
+
+    return req
+end
+
+function on_response(resp)
+    if original_tokens[resp:id()] then
+        -- Token is inside response body
+        original_tokens[resp:id()] = resp:body()
+        gor.log("Remember original token:", resp:body())
+    end
+
+    return resp
+end
+
+function on_replayed_response(resp)
+    local original_token = original_tokens[resp:id()]
+
+    if original_token then
+        original_tokens[resp:id()] = nil
+        token_aliases[original_token] = resp:body()
+        gor.log("Create alias for new token, was:", original_token, "now:", resp:body())
+    end
+
+    return resp
+end
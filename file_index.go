@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Index granularity: at most one entry per second of recorded traffic
+const fileIndexInterval = int64(time.Second)
+
+// fileIndexEntry points to the first request recorded at given time
+type fileIndexEntry struct {
+	timestamp int64
+	offset    int64
+}
+
+// fileIndexPath returns path of sidecar index file for given recording
+func fileIndexPath(path string) string {
+	return path + ".idx"
+}
+
+// fileIndexWriter writes sidecar index file, which maps request timestamps to byte offsets inside recording.
+// It allows to start replay from the middle of the file without reading it completely.
+//
+// Index is a text file where each line is `timestamp offset`:
+//
+//	1441461600000000000 0
+//	1441461601000230000 35121
+type fileIndexWriter struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+
+	lastTimestamp int64
+}
+
+func newFileIndexWriter(path string) (*fileIndexWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileIndexWriter{path: path, file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// Add records request position, if it is far enough from the previous entry
+func (w *fileIndexWriter) Add(timestamp, offset int64) {
+	if w.lastTimestamp != 0 && timestamp-w.lastTimestamp < fileIndexInterval {
+		return
+	}
+
+	w.lastTimestamp = timestamp
+
+	fmt.Fprintf(w.writer, "%d %d\n", timestamp, offset)
+}
+
+func (w *fileIndexWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+func (w *fileIndexWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// readFileIndex loads index entries, which are sorted by timestamp
+func readFileIndex(path string) (entries []fileIndexEntry, err error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		// Last line can be incomplete if recording still in progress
+		if len(fields) != 2 {
+			continue
+		}
+
+		var e fileIndexEntry
+
+		if e.timestamp, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+			return nil, err
+		}
+
+		if e.offset, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// fileIndexOffset returns offset of the latest indexed request which started before given timestamp
+func fileIndexOffset(entries []fileIndexEntry, timestamp int64) int64 {
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].timestamp > timestamp
+	})
+
+	if i == 0 {
+		return 0
+	}
+
+	return entries[i-1].offset
+}
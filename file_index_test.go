@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileIndexOffset(t *testing.T) {
+	entries := []fileIndexEntry{{10, 0}, {20, 100}, {30, 200}}
+
+	cases := map[int64]int64{5: 0, 10: 0, 15: 0, 20: 100, 29: 100, 35: 200}
+
+	for ts, expected := range cases {
+		if offset := fileIndexOffset(entries, ts); offset != expected {
+			t.Error("Wrong offset for", ts, offset, "expected:", expected)
+		}
+	}
+}
+
+func TestFileInputTimeRange(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_index")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "requests.gor")
+	start := time.Now().Add(-time.Hour)
+
+	output := NewFileOutput(path, &FileOutputConfig{index: true}).(*FileOutput)
+
+	// Request every 300ms
+	for i := 0; i < 10; i++ {
+		ts := start.Add(time.Duration(i) * 300 * time.Millisecond).UnixNano()
+		output.Write([]byte(fmt.Sprintf("1 %d %d\nGET /%d HTTP/1.1\r\n\r\n", i, ts, i)))
+		output.Write([]byte(fmt.Sprintf("2 %d 1\nHTTP/1.1 200 OK\r\n\r\n", i)))
+	}
+	output.Close()
+
+	index, err := readFileIndex(fileIndexPath(path))
+	if err != nil || len(index) != 3 {
+		t.Fatal("Index should contain entry for each second:", index, err)
+	}
+
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	config := &FileInputConfig{}
+	config.from.Time = start.Add(1500 * time.Millisecond)
+	config.to.Time = start.Add(2200 * time.Millisecond)
+
+	var received []string
+	var mu sync.Mutex
+
+	input := NewFileInput(path, config)
+	output2 := NewTestOutput(func(data []byte) {
+		mu.Lock()
+		received = append(received, string(payloadBody(data)))
+		mu.Unlock()
+		wg.Done()
+	})
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = []io.Writer{output2}
+
+	// Requests 5, 6 and 7 with their responses
+	wg.Add(6)
+	go Start(quit)
+	wg.Wait()
+	close(quit)
+
+	if received[0] != "GET /5 HTTP/1.1\r\n\r\n" || received[4] != "GET /7 HTTP/1.1\r\n\r\n" {
+		t.Error("Should replay only requested time range:", received)
+	}
+}
+
+func TestFileInputTimeRangeOutOfOrder(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_index")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "requests.gor")
+	start := time.Now().Add(-time.Hour)
+
+	output := NewFileOutput(path, &FileOutputConfig{index: true}).(*FileOutput)
+
+	// Long request started at 1.4s completed after request started at 2.5s
+	for i, offset := range []time.Duration{0, 1000, 2500, 1400, 3000} {
+		ts := start.Add(offset * time.Millisecond).UnixNano()
+		output.Write([]byte(fmt.Sprintf("1 %d %d\nGET /%d HTTP/1.1\r\n\r\n", i, ts, i)))
+	}
+	output.Close()
+
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	config := &FileInputConfig{}
+	config.from.Time = start.Add(1000 * time.Millisecond)
+	config.to.Time = start.Add(2000 * time.Millisecond)
+
+	var received []string
+	var mu sync.Mutex
+
+	input := NewFileInput(path, config)
+	output2 := NewTestOutput(func(data []byte) {
+		mu.Lock()
+		received = append(received, string(payloadBody(data)))
+		mu.Unlock()
+		wg.Done()
+	})
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = []io.Writer{output2}
+
+	wg.Add(2)
+	go Start(quit)
+	wg.Wait()
+	close(quit)
+
+	if received[0] != "GET /1 HTTP/1.1\r\n\r\n" || received[1] != "GET /3 HTTP/1.1\r\n\r\n" {
+		t.Error("Out of order request inside time range should be replayed:", received)
+	}
+}
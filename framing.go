@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+// Payloads are framed when written to file or sent over TCP.
+//
+// Legacy format separates payloads with `payloadSeparator` string, which may appear inside binary body.
+// Binary format prefixes each payload with its length, and stores payload meta as typed fields:
+//
+//	Stream header: "\x00GOR" magic, version (1 byte), flags (1 byte)
+//	Frame:         content length (uvarint), content, [CRC32 of content, 4 bytes, if checksum flag set]
+//	Content:       payload type (1 byte), fields count (uvarint), fields, body
+//	Field:         tag (1 byte), value length (uvarint), value
+//
+// Readers detect format by stream header, so old separator-delimited files can still be read.
+const (
+	framingBinary    = "binary"
+	framingSeparator = "separator"
+
+	framingVersion = 1
+
+	// Stream header flags
+	framingFlagChecksum = 1 << 0
+
+	// Frame field tags
+	frameTagUUID   = 1
+	frameTagTiming = 2
+	// Any other space separated value of payload header, stored as is
+	frameTagExtra = 3
+)
+
+var framingMagic = []byte("\x00GOR")
+
+// Length of stream header: magic, version and flags
+var framingHeaderSize = len(framingMagic) + 2
+
+// Protects from allocating huge buffers on corrupted streams
+const maxFrameSize = 64 * 1024 * 1024
+
+var (
+	errFrameChecksum  = errors.New("Payload frame checksum mismatch")
+	errFrameTooLarge  = errors.New("Payload frame is too large")
+	errFrameMalformed = errors.New("Payload frame is malformed")
+)
+
+// payloadEncoder writes payloads using configured framing format
+type payloadEncoder struct {
+	w        io.Writer
+	binary   bool
+	checksum bool
+
+	headerWritten bool
+	buf           []byte
+}
+
+func newPayloadEncoder(w io.Writer, format string, checksum bool) *payloadEncoder {
+	return &payloadEncoder{w: w, binary: format == framingBinary, checksum: checksum}
+}
+
+func (e *payloadEncoder) writeHeader() (int, error) {
+	header := make([]byte, framingHeaderSize)
+	copy(header, framingMagic)
+	header[len(framingMagic)] = framingVersion
+
+	if e.checksum {
+		header[len(framingMagic)+1] |= framingFlagChecksum
+	}
+
+	return e.w.Write(header)
+}
+
+// Encode writes single payload, returns number of bytes written to underlying writer
+func (e *payloadEncoder) Encode(payload []byte) (n int, err error) {
+	return e.WriteFrame(e.Frame(payload))
+}
+
+// Frame returns payload in configured framing format, its length is the number of bytes payload takes in stream.
+// Stream header is not included. Returned slice valid until next call.
+func (e *payloadEncoder) Frame(payload []byte) []byte {
+	if !e.binary {
+		e.buf = append(append(e.buf[:0], payload...), payloadSeparator...)
+		return e.buf
+	}
+
+	// Content built after space reserved for its length, which is known only when content is ready
+	var lenBuf [binary.MaxVarintLen64]byte
+	e.buf = appendFrameContent(append(e.buf[:0], lenBuf[:]...), payload)
+	content := e.buf[len(lenBuf):]
+
+	if e.checksum {
+		e.buf = append(e.buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], crc32.ChecksumIEEE(content))
+	}
+
+	l := binary.PutUvarint(lenBuf[:], uint64(len(content)))
+	start := len(lenBuf) - l
+	copy(e.buf[start:], lenBuf[:l])
+
+	return e.buf[start:]
+}
+
+// WriteFrame writes payload framed by Frame, preceded by stream header if it is first frame
+func (e *payloadEncoder) WriteFrame(frame []byte) (n int, err error) {
+	if e.binary && !e.headerWritten {
+		if n, err = e.writeHeader(); err != nil {
+			return
+		}
+		e.headerWritten = true
+	}
+
+	m, err := e.w.Write(frame)
+
+	return n + m, err
+}
+
+// appendFrameContent converts payload header into typed fields
+func appendFrameContent(buf []byte, payload []byte) []byte {
+	headerSize := bytes.IndexByte(payload, '\n')
+	if headerSize == -1 {
+		headerSize = len(payload)
+	}
+
+	meta := bytes.Split(payload[:headerSize], []byte{' '})
+
+	var body []byte
+	if headerSize < len(payload) {
+		body = payload[headerSize+1:]
+	}
+
+	var payloadType byte
+	if len(meta[0]) > 0 {
+		payloadType = meta[0][0]
+	}
+	buf = append(buf, payloadType)
+	buf = appendUvarint(buf, uint64(len(meta)-1))
+
+	for i, value := range meta[1:] {
+		tag := byte(frameTagExtra)
+
+		switch i {
+		case 0:
+			tag = frameTagUUID
+		case 1:
+			// Timing stored as number if possible
+			if timing, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+				var vBuf [binary.MaxVarintLen64]byte
+				value = vBuf[:binary.PutVarint(vBuf[:], timing)]
+				tag = frameTagTiming
+			}
+		}
+
+		buf = append(buf, tag)
+		buf = appendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+	}
+
+	return append(buf, body...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var vBuf [binary.MaxVarintLen64]byte
+	return append(buf, vBuf[:binary.PutUvarint(vBuf[:], v)]...)
+}
+
+// payloadDecoder reads payloads, detecting framing format by stream header
+type payloadDecoder struct {
+	reader   *bufio.Reader
+	scanner  *bufio.Scanner
+	checksum bool
+}
+
+func newPayloadDecoder(r io.Reader) (*payloadDecoder, error) {
+	d := &payloadDecoder{reader: bufio.NewReader(r)}
+
+	// Peek returns error if stream shorter than header, such streams are handled as legacy
+	header, _ := d.reader.Peek(framingHeaderSize)
+
+	if !bytes.HasPrefix(header, framingMagic) {
+		d.scanner = bufio.NewScanner(d.reader)
+		d.scanner.Buffer(make([]byte, 64*1024), maxFrameSize)
+		d.scanner.Split(payloadScanner)
+
+		return d, nil
+	}
+
+	if len(header) < framingHeaderSize {
+		return nil, errFrameMalformed
+	}
+
+	if version := header[len(framingMagic)]; version != framingVersion {
+		return nil, fmt.Errorf("Unsupported payload framing version: %d", version)
+	}
+
+	d.checksum = header[len(framingMagic)+1]&framingFlagChecksum != 0
+	d.reader.Discard(framingHeaderSize)
+
+	return d, nil
+}
+
+// readFramingHeader reads stream header of given reader, returns nil if stream uses legacy framing.
+// Used to decode streams from the middle: header should be prepended to the data read after seek.
+func readFramingHeader(r io.ReaderAt) []byte {
+	header := make([]byte, framingHeaderSize)
+
+	if _, err := r.ReadAt(header, 0); err != nil || !bytes.HasPrefix(header, framingMagic) {
+		return nil
+	}
+
+	return header
+}
+
+// Decode returns next payload, or io.EOF when stream is finished
+// Returned slice is valid only until next call
+func (d *payloadDecoder) Decode() ([]byte, error) {
+	if d.scanner != nil {
+		if d.scanner.Scan() {
+			return d.scanner.Bytes(), nil
+		}
+
+		if err := d.scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	size, err := binary.ReadUvarint(d.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > maxFrameSize {
+		return nil, errFrameTooLarge
+	}
+
+	frameSize := int(size)
+	if d.checksum {
+		frameSize += 4
+	}
+
+	frame := make([]byte, frameSize)
+	if _, err = io.ReadFull(d.reader, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	content := frame[:size]
+
+	if d.checksum && binary.BigEndian.Uint32(frame[size:]) != crc32.ChecksumIEEE(content) {
+		return nil, errFrameChecksum
+	}
+
+	return decodeFrameContent(content)
+}
+
+// decodeFrameContent converts typed fields back to the payload header
+func decodeFrameContent(content []byte) ([]byte, error) {
+	if len(content) == 0 {
+		return nil, errFrameMalformed
+	}
+
+	payload := make([]byte, 0, len(content)+64)
+	payload = append(payload, content[0])
+	content = content[1:]
+
+	count, n := binary.Uvarint(content)
+	if n <= 0 {
+		return nil, errFrameMalformed
+	}
+	content = content[n:]
+
+	for i := uint64(0); i < count; i++ {
+		if len(content) == 0 {
+			return nil, errFrameMalformed
+		}
+
+		tag := content[0]
+		l, n := binary.Uvarint(content[1:])
+		if n <= 0 || uint64(len(content)-1-n) < l {
+			return nil, errFrameMalformed
+		}
+
+		value := content[1+n : 1+n+int(l)]
+		content = content[1+n+int(l):]
+
+		payload = append(payload, ' ')
+
+		if tag == frameTagTiming {
+			timing, n := binary.Varint(value)
+			if n <= 0 {
+				return nil, errFrameMalformed
+			}
+			payload = strconv.AppendInt(payload, timing, 10)
+		} else {
+			payload = append(payload, value...)
+		}
+	}
+
+	payload = append(payload, '\n')
+
+	return append(payload, content...), nil
+}
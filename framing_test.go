@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPayloadFramingBinary(t *testing.T) {
+	payloads := [][]byte{
+		[]byte("1 f45590522cd1838b4a0d5c5aab80b77929dea3b3 1441461600000000000\nGET / HTTP/1.1\r\n\r\n"),
+		// Body containing legacy separator should not split the payload
+		[]byte("1 a 1\nPOST / HTTP/1.1\r\nContent-Length: 17\r\n\r\n" + payloadSeparator + "end"),
+		[]byte("2 a -1\nHTTP/1.1 200 OK\r\n\r\n"),
+		// Not numeric timing and additional fields
+		[]byte("3 b abc extra\n"),
+	}
+
+	for _, checksum := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		encoder := newPayloadEncoder(buf, framingBinary, checksum)
+
+		for _, p := range payloads {
+			encoder.Encode(p)
+		}
+
+		if !bytes.HasPrefix(buf.Bytes(), framingMagic) {
+			t.Error("Stream should start with magic header")
+		}
+
+		decoder, err := newPayloadDecoder(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range payloads {
+			decoded, err := decoder.Decode()
+
+			if err != nil {
+				t.Fatal("Should decode payload", err)
+			}
+
+			if !bytes.Equal(decoded, p) {
+				t.Errorf("Payload not match, expected:\n%q\ngot:\n%q", p, decoded)
+			}
+		}
+
+		if _, err := decoder.Decode(); err != io.EOF {
+			t.Error("Should return EOF at the end of stream", err)
+		}
+	}
+}
+
+func TestPayloadFramingLegacy(t *testing.T) {
+	buf := new(bytes.Buffer)
+	encoder := newPayloadEncoder(buf, framingSeparator, false)
+
+	encoder.Encode([]byte("1 a 1\nGET / HTTP/1.1\r\n\r\n"))
+	encoder.Encode([]byte("2 a 1\nHTTP/1.1 200 OK\r\n\r\n"))
+
+	if buf.String() != "1 a 1\nGET / HTTP/1.1\r\n\r\n"+payloadSeparator+"2 a 1\nHTTP/1.1 200 OK\r\n\r\n"+payloadSeparator {
+		t.Errorf("Should use legacy format: %q", buf.String())
+	}
+
+	decoder, _ := newPayloadDecoder(buf)
+
+	if p, _ := decoder.Decode(); string(p) != "1 a 1\nGET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("Wrong payload: %q", p)
+	}
+
+	if p, _ := decoder.Decode(); string(p) != "2 a 1\nHTTP/1.1 200 OK\r\n\r\n" {
+		t.Errorf("Wrong payload: %q", p)
+	}
+
+	if _, err := decoder.Decode(); err != io.EOF {
+		t.Error("Should return EOF at the end of stream", err)
+	}
+}
+
+func TestPayloadFramingChecksum(t *testing.T) {
+	buf := new(bytes.Buffer)
+	encoder := newPayloadEncoder(buf, framingBinary, true)
+	encoder.Encode([]byte("1 a 1\nGET / HTTP/1.1\r\n\r\n"))
+
+	data := buf.Bytes()
+	// Corrupt body
+	data[len(data)-6] = 'X'
+
+	decoder, _ := newPayloadDecoder(bytes.NewReader(data))
+
+	if _, err := decoder.Decode(); err != errFrameChecksum {
+		t.Error("Should detect corrupted payload", err)
+	}
+}
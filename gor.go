@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	_ "runtime/debug"
 	"runtime/pprof"
+	"syscall"
 	"time"
 )
 
@@ -49,7 +51,20 @@ func main() {
 		profileCPU(*cpuprofile)
 	}
 
-	Start(nil)
+	stop := make(chan int)
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		log.Println("Received", <-signals, "signal, stopping")
+		close(stop)
+	}()
+
+	Start(stop)
+
+	// Flush buffered data and finish compressed streams
+	closeOutputs()
 }
 
 func profileCPU(cpuprofile string) {
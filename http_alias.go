@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/gor/processor"
+	"github.com/buger/gor/proto"
+)
+
+const (
+	// How long to wait for both original and replayed response of the same request
+	aliasPendingTTL = time.Minute
+	// Aliases not used for this time are forgotten
+	aliasTTL = time.Hour
+)
+
+// aliasSource describes where to find value in response: `header:<name>`, `cookie:<name>`, `json:<path>`, `regexp:<expr>` or `body`
+type aliasSource struct {
+	kind string
+	name []byte
+	path []string
+	re   *regexp.Regexp
+}
+
+// aliasTarget describes where to substitute values in request: `header:<name>`, `cookie:<name>`, `param:<name>` or `body`
+type aliasTarget struct {
+	kind string
+	name []byte
+}
+
+type aliasRule struct {
+	original aliasSource
+	replayed aliasSource
+	target   aliasTarget
+}
+
+type aliasPending struct {
+	original []byte
+	replayed []byte
+	seen     time.Time
+}
+
+type aliasValue struct {
+	value []byte
+	seen  time.Time
+}
+
+// AliasProcessor learns `original -> replayed` value aliases (like auth tokens or session ids) from
+// original and replayed responses of the same request, and substitutes them in the following requests.
+//
+// Rule format: `<source> => <target>`, or `<original source> | <replayed source> => <target>` if replayed
+// environment returns value in different place. Aliases are shared between rules.
+//
+//	json:access_token => header:Authorization
+//	cookie:session | header:X-Session => cookie:session
+type AliasProcessor struct {
+	rules []aliasRule
+
+	mu        sync.Mutex
+	pending   map[string]*aliasPending
+	aliases   map[string]aliasValue
+	lastSweep time.Time
+}
+
+// NewAliasProcessor constructor for AliasProcessor, fails if rule can't be parsed
+func NewAliasProcessor(rules []string) *AliasProcessor {
+	p := new(AliasProcessor)
+	p.pending = make(map[string]*aliasPending)
+	p.aliases = make(map[string]aliasValue)
+	p.lastSweep = time.Now()
+
+	for _, r := range rules {
+		rule, err := parseAliasRule(r)
+		if err != nil {
+			log.Fatal("Cannot parse alias rule: ", err)
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	return p
+}
+
+func parseAliasRule(value string) (rule aliasRule, err error) {
+	i := strings.LastIndex(value, "=>")
+	if i == -1 {
+		return rule, fmt.Errorf("expected `<source> => <target>`, got: %s", value)
+	}
+
+	sources := strings.SplitN(value[:i], " | ", 2)
+
+	if rule.original, err = parseAliasSource(strings.TrimSpace(sources[0])); err != nil {
+		return
+	}
+
+	rule.replayed = rule.original
+	if len(sources) > 1 {
+		if rule.replayed, err = parseAliasSource(strings.TrimSpace(sources[1])); err != nil {
+			return
+		}
+	}
+
+	rule.target, err = parseAliasTarget(strings.TrimSpace(value[i+2:]))
+
+	return
+}
+
+func parseAliasSource(value string) (s aliasSource, err error) {
+	if value == "body" {
+		s.kind = value
+		return
+	}
+
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return s, fmt.Errorf("source should be `header:<name>`, `cookie:<name>`, `json:<path>`, `regexp:<expr>` or `body`, got: %s", value)
+	}
+
+	s.kind = kv[0]
+
+	switch s.kind {
+	case "header", "cookie":
+		s.name = []byte(kv[1])
+	case "json":
+		s.path = strings.Split(kv[1], ".")
+	case "regexp":
+		s.re, err = regexp.Compile(kv[1])
+	default:
+		err = fmt.Errorf("unknown source type: %s", s.kind)
+	}
+
+	return
+}
+
+func parseAliasTarget(value string) (t aliasTarget, err error) {
+	if value == "body" {
+		t.kind = value
+		return
+	}
+
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) != 2 || kv[1] == "" || (kv[0] != "header" && kv[0] != "cookie" && kv[0] != "param") {
+		return t, fmt.Errorf("target should be `header:<name>`, `cookie:<name>`, `param:<name>` or `body`, got: %s", value)
+	}
+
+	t.kind, t.name = kv[0], []byte(kv[1])
+
+	return
+}
+
+// Process learns aliases from responses and rewrites requests
+func (p *AliasProcessor) Process(msg *processor.Message) []*processor.Message {
+	switch msg.Type {
+	case processor.Request:
+		p.mu.Lock()
+		p.sweep()
+		p.mu.Unlock()
+
+		for _, rule := range p.rules {
+			msg.Data = p.substitute(rule.target, msg.Data)
+		}
+	case processor.Response, processor.ReplayedResponse:
+		for i, rule := range p.rules {
+			source := rule.original
+			if msg.Type == processor.ReplayedResponse {
+				source = rule.replayed
+			}
+
+			if value := source.extract(msg.Data); len(value) > 0 {
+				p.learn(strconv.Itoa(i)+" "+string(msg.ID), msg.Type, value)
+			}
+		}
+	}
+
+	return []*processor.Message{msg}
+}
+
+// learn remembers value until response of the other type arrives
+func (p *AliasProcessor) learn(key string, payloadType byte, value []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, ok := p.pending[key]
+	if !ok {
+		pending = &aliasPending{seen: time.Now()}
+		p.pending[key] = pending
+	}
+
+	if payloadType == processor.Response {
+		pending.original = value
+	} else {
+		pending.replayed = value
+	}
+
+	if pending.original == nil || pending.replayed == nil {
+		return
+	}
+
+	delete(p.pending, key)
+
+	if !bytes.Equal(pending.original, pending.replayed) {
+		Debug("[ALIAS] Found alias, original:", string(pending.original), "replayed:", string(pending.replayed))
+		p.aliases[string(pending.original)] = aliasValue{pending.replayed, time.Now()}
+	}
+}
+
+// alias returns replayed value for original one, or nil if not known
+func (p *AliasProcessor) alias(original []byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a, ok := p.aliases[string(original)]
+	if !ok {
+		return nil
+	}
+
+	a.seen = time.Now()
+	p.aliases[string(original)] = a
+
+	return a.value
+}
+
+// sweep removes stale pending values and aliases. Should be called under lock.
+func (p *AliasProcessor) sweep() {
+	now := time.Now()
+	if now.Sub(p.lastSweep) < aliasPendingTTL/10 {
+		return
+	}
+	p.lastSweep = now
+
+	for key, pending := range p.pending {
+		if now.Sub(pending.seen) > aliasPendingTTL {
+			delete(p.pending, key)
+		}
+	}
+
+	for key, a := range p.aliases {
+		if now.Sub(a.seen) > aliasTTL {
+			delete(p.aliases, key)
+		}
+	}
+}
+
+// substitute replaces known original values inside request target
+func (p *AliasProcessor) substitute(t aliasTarget, payload []byte) []byte {
+	if proto.MIMEHeadersEndPos(payload) == -1 {
+		return payload
+	}
+
+	switch t.kind {
+	case "header":
+		value := proto.Header(payload, t.name)
+		if len(value) == 0 {
+			return payload
+		}
+
+		if replaced := replaceAliasWords(value, p.alias); replaced != nil {
+			return proto.SetHeader(payload, t.name, replaced)
+		}
+	case "cookie":
+		cookie := proto.Header(payload, []byte("Cookie"))
+		value, start, end := cookieValue(cookie, t.name)
+		if value == nil {
+			return payload
+		}
+
+		if alias := p.alias(value); alias != nil {
+			updated := append(append(append([]byte{}, cookie[:start]...), alias...), cookie[end:]...)
+			return proto.SetHeader(payload, []byte("Cookie"), updated)
+		}
+	case "param":
+		value, _, _ := proto.PathParam(payload, t.name)
+		if len(value) == 0 {
+			return payload
+		}
+
+		if alias := p.alias(value); alias != nil {
+			return proto.SetPathParam(payload, t.name, alias)
+		}
+	case "body":
+		if replaced := replaceAliasWords(proto.Body(payload), p.alias); replaced != nil {
+			return proto.SetBody(payload, replaced)
+		}
+	}
+
+	return payload
+}
+
+// extract finds value in response payload
+func (s *aliasSource) extract(payload []byte) []byte {
+	if proto.MIMEHeadersEndPos(payload) == -1 {
+		return nil
+	}
+
+	var value []byte
+
+	switch s.kind {
+	case "header":
+		value = proto.Header(payload, s.name)
+	case "cookie":
+		value = setCookieValue(payload, s.name)
+	case "json":
+		value = jsonPathValue(proto.Body(payload), s.path)
+	case "regexp":
+		if m := s.re.FindSubmatch(payload); m != nil {
+			value = m[len(m)-1]
+		}
+	case "body":
+		value = bytes.TrimSpace(proto.Body(payload))
+	}
+
+	if len(value) == 0 {
+		return nil
+	}
+
+	// Payload buffer can be reused
+	return append([]byte(nil), value...)
+}
+
+// setCookieValue returns value of cookie with given name set by response, checks all `Set-Cookie` headers
+func setCookieValue(payload, name []byte) []byte {
+	for _, cookie := range proto.Headers(payload, []byte("Set-Cookie")) {
+		if end := bytes.IndexByte(cookie, ';'); end != -1 {
+			cookie = cookie[:end]
+		}
+
+		if kv := bytes.SplitN(cookie, []byte("="), 2); len(kv) == 2 && bytes.Equal(bytes.TrimSpace(kv[0]), name) {
+			return bytes.TrimSpace(kv[1])
+		}
+	}
+
+	return nil
+}
+
+// cookieValue returns value of cookie with given name from `Cookie` header value, and value position
+func cookieValue(header, name []byte) (value []byte, start, end int) {
+	for pos := 0; pos < len(header); {
+		next := bytes.IndexByte(header[pos:], ';')
+		if next == -1 {
+			next = len(header)
+		} else {
+			next += pos
+		}
+
+		pair := header[pos:next]
+		if eq := bytes.IndexByte(pair, '='); eq != -1 && bytes.Equal(bytes.TrimSpace(pair[:eq]), name) {
+			start = pos + eq + 1
+			return header[start:next], start, next
+		}
+
+		pos = next + 1
+	}
+
+	return nil, -1, -1
+}
+
+// jsonPathValue returns value by dot separated path, array elements can be accessed by index: `data.tokens.0`
+func jsonPathValue(body []byte, path []string) []byte {
+	var value interface{}
+
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil
+	}
+
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	return nil
+}
+
+func isAliasSeparator(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '"', '\'', ',', ';', '&', ':', '{', '}', '[', ']', '(', ')', '<', '>':
+		return true
+	}
+
+	return false
+}
+
+// replaceAliasWords looks up each word of value (like `Bearer <token>`, or JSON string) and replaces known aliases.
+// For `key=value` words value part is checked as well. Returns nil if nothing replaced.
+func replaceAliasWords(value []byte, lookup func([]byte) []byte) []byte {
+	var out []byte
+	last := 0
+
+	for i := 0; i < len(value); {
+		if isAliasSeparator(value[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(value) && !isAliasSeparator(value[i]) {
+			i++
+		}
+
+		word := value[start:i]
+		alias := lookup(word)
+
+		if alias == nil {
+			if eq := bytes.IndexByte(word, '='); eq != -1 && eq < len(word)-1 {
+				if alias = lookup(word[eq+1:]); alias != nil {
+					start += eq + 1
+				}
+			}
+		}
+
+		if alias != nil {
+			out = append(out, value[last:start]...)
+			out = append(out, alias...)
+			last = i
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return append(out, value[last:]...)
+}
+
+func (p *AliasProcessor) String() string {
+	return fmt.Sprintf("Alias processor with %d rules", len(p.rules))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/buger/gor/processor"
+)
+
+func TestParseAliasRule(t *testing.T) {
+	rule, err := parseAliasRule("cookie:session | regexp:token=(\\w+) => param:sid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rule.original.kind != "cookie" || rule.replayed.kind != "regexp" || rule.target.kind != "param" || string(rule.target.name) != "sid" {
+		t.Error("Wrong rule", rule)
+	}
+
+	for _, r := range []string{"json:token", "json:token => query:token", "xml:token => body", " => body"} {
+		if _, err := parseAliasRule(r); err == nil {
+			t.Error("Should fail to parse", r)
+		}
+	}
+}
+
+func TestAliasProcessor(t *testing.T) {
+	p := NewAliasProcessor([]string{
+		"json:data.token => header:Authorization",
+		"cookie:sid => cookie:sid",
+		"regexp:key=(\\w+) => param:key",
+		"json:data.token => body",
+	})
+
+	process := func(payloadType byte, id, data string) string {
+		msg := &processor.Message{Type: payloadType, ID: []byte(id), Data: []byte(data)}
+		return string(p.Process(msg)[0].Data)
+	}
+
+	// Replayed response can arrive before original one
+	process(processor.ReplayedResponse, "1", "HTTP/1.1 200 OK\r\nSet-Cookie: a=1\r\nSet-Cookie: sid=new; Path=/\r\nX-Key: key=k2\r\n\r\n{\"data\":{\"token\":\"bbbb\"}}")
+	process(processor.Response, "1", "HTTP/1.1 200 OK\r\nSet-Cookie: sid=old; Path=/\r\nX-Key: key=k1\r\n\r\n{\"data\":{\"token\":\"aaa\"}}")
+
+	cases := []struct {
+		req, expected string
+	}{
+		{"GET / HTTP/1.1\r\nAuthorization: Bearer aaa\r\n\r\n", "GET / HTTP/1.1\r\nAuthorization: Bearer bbbb\r\n\r\n"},
+		{"GET / HTTP/1.1\r\nCookie: a=1; sid=old; b=2\r\n\r\n", "GET / HTTP/1.1\r\nCookie: a=1; sid=new; b=2\r\n\r\n"},
+		{"GET /?key=k1&x=1 HTTP/1.1\r\n\r\n", "GET /?key=k2&x=1 HTTP/1.1\r\n\r\n"},
+		{"POST / HTTP/1.1\r\nContent-Length: 17\r\n\r\n{\"t\":\"aaa\",\"x\":1}", "POST / HTTP/1.1\r\nContent-Length: 18\r\n\r\n{\"t\":\"bbbb\",\"x\":1}"},
+		{"POST / HTTP/1.1\r\nContent-Length: 12\r\n\r\nt=aaa&x=aaaa", "POST / HTTP/1.1\r\nContent-Length: 13\r\n\r\nt=bbbb&x=aaaa"},
+		{"GET / HTTP/1.1\r\nAuthorization: Bearer aaaa\r\n\r\n", "GET / HTTP/1.1\r\nAuthorization: Bearer aaaa\r\n\r\n"},
+	}
+
+	for _, c := range cases {
+		if actual := process(processor.Request, "2", c.req); actual != c.expected {
+			t.Errorf("Expected %q, got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestReplaceAliasWords(t *testing.T) {
+	lookup := func(word []byte) []byte {
+		if bytes.Equal(word, []byte("abc")) {
+			return []byte("xyz")
+		}
+		return nil
+	}
+
+	if out := replaceAliasWords([]byte("abc, abcd;abc"), lookup); string(out) != "xyz, abcd;xyz" {
+		t.Errorf("Wrong replacement: %q", out)
+	}
+
+	if replaceAliasWords([]byte("abcd"), lookup) != nil {
+		t.Error("Should return nil if nothing replaced")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+)
+
+// Byte-level JSON and form body editing, which keeps rest of the body untouched (keys order, formatting)
+
+// jsonMember is position of object member or array element inside JSON document
+type jsonMember struct {
+	// Start of member, including key for objects
+	start      int
+	valueStart int
+	valueEnd   int
+}
+
+func jsonSkipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+		i++
+	}
+
+	return i
+}
+
+// jsonSkipString returns position after string which starts at i, or -1 if string not terminated
+func jsonSkipString(data []byte, i int) int {
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// jsonSkipValue returns position after value which starts at i, or -1 if value invalid
+func jsonSkipValue(data []byte, i int) int {
+	return jsonWalk(data, i, nil, nil)
+}
+
+// jsonWalk walks value which starts at i, and calls fn for each member matching path.
+// Path elements are object keys or array indexes, `*` matches any member.
+// Returns position after value, or -1 if value invalid.
+func jsonWalk(data []byte, i int, path []string, fn func(m jsonMember)) int {
+	i = jsonSkipSpace(data, i)
+	if i >= len(data) {
+		return -1
+	}
+
+	switch data[i] {
+	case '"':
+		return jsonSkipString(data, i)
+	case '{', '[':
+		object := data[i] == '{'
+		closing := byte(']')
+		if object {
+			closing = '}'
+		}
+
+		i = jsonSkipSpace(data, i+1)
+		if i < len(data) && data[i] == closing {
+			return i + 1
+		}
+
+		for index := 0; i < len(data); index++ {
+			m := jsonMember{start: i}
+			key := strconv.Itoa(index)
+
+			if object {
+				if data[i] != '"' {
+					return -1
+				}
+
+				keyEnd := jsonSkipString(data, i)
+				if keyEnd == -1 {
+					return -1
+				}
+				key = string(data[i+1 : keyEnd-1])
+
+				i = jsonSkipSpace(data, keyEnd)
+				if i >= len(data) || data[i] != ':' {
+					return -1
+				}
+				i++
+			}
+
+			m.valueStart = jsonSkipSpace(data, i)
+
+			if len(path) > 0 && (path[0] == "*" || path[0] == key) {
+				if len(path) == 1 {
+					m.valueEnd = jsonSkipValue(data, m.valueStart)
+					if m.valueEnd != -1 {
+						fn(m)
+					}
+				} else {
+					m.valueEnd = jsonWalk(data, m.valueStart, path[1:], fn)
+				}
+			} else {
+				m.valueEnd = jsonSkipValue(data, m.valueStart)
+			}
+
+			if m.valueEnd == -1 {
+				return -1
+			}
+
+			i = jsonSkipSpace(data, m.valueEnd)
+			if i >= len(data) {
+				return -1
+			}
+
+			switch data[i] {
+			case ',':
+				i = jsonSkipSpace(data, i+1)
+			case closing:
+				return i + 1
+			default:
+				return -1
+			}
+		}
+
+		return -1
+	default:
+		// Numbers and literals
+		start := i
+	scan:
+		for ; i < len(data); i++ {
+			switch data[i] {
+			case ',', '}', ']', ' ', '\t', '\r', '\n':
+				break scan
+			}
+		}
+
+		if i == start {
+			return -1
+		}
+
+		return i
+	}
+}
+
+// jsonMembers returns all members matching path, or nil if body is not valid JSON
+func jsonMembers(data []byte, path []string) (members []jsonMember) {
+	end := jsonWalk(data, 0, path, func(m jsonMember) {
+		members = append(members, m)
+	})
+
+	if end == -1 {
+		return nil
+	}
+
+	return
+}
+
+// jsonSet sets value (already encoded JSON) of all members matching path.
+// If member not found, and path do not contain wildcards, member added to the parent object.
+// Returns nil if nothing changed.
+func jsonSet(data []byte, path []string, value []byte) []byte {
+	members := jsonMembers(data, path)
+
+	if len(members) == 0 {
+		return jsonInsert(data, path, value)
+	}
+
+	out := append([]byte(nil), data...)
+
+	// From the end, so positions of previous members stay valid
+	for i := len(members) - 1; i >= 0; i-- {
+		m := members[i]
+		tail := append(append([]byte(nil), value...), out[m.valueEnd:]...)
+		out = append(out[:m.valueStart], tail...)
+	}
+
+	return out
+}
+
+// jsonInsert adds new member to the object at parent path
+func jsonInsert(data []byte, path []string, value []byte) []byte {
+	key := path[len(path)-1]
+
+	for _, p := range path {
+		if p == "*" {
+			return nil
+		}
+	}
+
+	var start, end int
+
+	if len(path) == 1 {
+		start = jsonSkipSpace(data, 0)
+		if end = jsonSkipValue(data, start); end == -1 {
+			return nil
+		}
+	} else {
+		parents := jsonMembers(data, path[:len(path)-1])
+		if len(parents) != 1 {
+			return nil
+		}
+		start, end = parents[0].valueStart, parents[0].valueEnd
+	}
+
+	if start >= len(data) || data[start] != '{' {
+		return nil
+	}
+
+	member := append([]byte(strconv.Quote(key)+":"), value...)
+
+	// Empty object
+	if jsonSkipSpace(data, start+1) != end-1 {
+		member = append([]byte(","), member...)
+	}
+
+	out := make([]byte, 0, len(data)+len(member))
+	out = append(out, data[:end-1]...)
+	out = append(out, member...)
+	out = append(out, data[end-1:]...)
+
+	return out
+}
+
+// jsonDelete removes all members matching path. Returns nil if nothing changed.
+func jsonDelete(data []byte, path []string) []byte {
+	members := jsonMembers(data, path)
+
+	if len(members) == 0 {
+		return nil
+	}
+
+	out := append([]byte(nil), data...)
+
+	for i := len(members) - 1; i >= 0; i-- {
+		start, end := members[i].start, members[i].valueEnd
+
+		// Remove separator: the following one, or previous one if member is the last
+		if next := jsonSkipSpace(out, end); next < len(out) && out[next] == ',' {
+			end = jsonSkipSpace(out, next+1)
+		} else {
+			prev := start - 1
+			for prev >= 0 && (out[prev] == ' ' || out[prev] == '\t' || out[prev] == '\r' || out[prev] == '\n') {
+				prev--
+			}
+
+			if prev >= 0 && out[prev] == ',' {
+				start = prev
+			}
+		}
+
+		out = append(out[:start], out[end:]...)
+	}
+
+	return out
+}
+
+// jsonValue encodes option value: valid JSON used as is, anything else treated as string
+func jsonValue(value []byte) []byte {
+	if len(value) > 0 && jsonSkipValue(value, 0) == len(value) && (value[0] == '"' || value[0] == '{' || value[0] == '[' || isJSONLiteral(value)) {
+		return value
+	}
+
+	return []byte(strconv.Quote(string(value)))
+}
+
+func isJSONLiteral(value []byte) bool {
+	switch string(value) {
+	case "true", "false", "null":
+		return true
+	}
+
+	_, err := strconv.ParseFloat(string(value), 64)
+
+	return err == nil
+}
+
+// formSet sets `application/x-www-form-urlencoded` body field, adds it if not found. Value is escaped.
+func formSet(body, name, value []byte) []byte {
+	encoded := []byte(url.QueryEscape(string(value)))
+	found := false
+
+	fields := bytes.Split(body, []byte("&"))
+	for i, field := range fields {
+		kv := bytes.SplitN(field, []byte("="), 2)
+
+		if key, err := url.QueryUnescape(string(kv[0])); err == nil && key == string(name) {
+			fields[i] = append(append(append([]byte(nil), kv[0]...), '='), encoded...)
+			found = true
+		}
+	}
+
+	if !found {
+		field := append(append([]byte(url.QueryEscape(string(name))), '='), encoded...)
+
+		if len(body) == 0 {
+			return field
+		}
+
+		fields = append(fields, field)
+	}
+
+	return bytes.Join(fields, []byte("&"))
+}
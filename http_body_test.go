@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONSet(t *testing.T) {
+	cases := []struct {
+		body, path, value, expected string
+	}{
+		{`{"a": 1, "b": {"c": "d"}}`, "b.c", `"e"`, `{"a": 1, "b": {"c": "e"}}`},
+		{`{"a": 1}`, "b", `2`, `{"a": 1,"b":2}`},
+		{`{"a": {}}`, "a.b", `null`, `{"a": {"b":null}}`},
+		{`[{"a": 1}, {"a": 2}, {"b": 3}]`, "*.a", `0`, `[{"a": 0}, {"a": 0}, {"b": 3}]`},
+		{`[1, 2, 3]`, "1", `"x"`, `[1, "x", 3]`},
+		{`{"a\"b": 1, "s": "}"}`, "s", `2`, `{"a\"b": 1, "s": 2}`},
+		{`{"a": [1, 2]}`, "a.b", `1`, ``},
+		{`{"a": 1`, "a", `2`, ``},
+		{`a=1&b=2`, "a", `2`, ``},
+	}
+
+	for _, c := range cases {
+		if out := jsonSet([]byte(c.body), strings.Split(c.path, "."), []byte(c.value)); string(out) != c.expected {
+			t.Errorf("Set %s in %s: expected %q, got %q", c.path, c.body, c.expected, out)
+		}
+	}
+}
+
+func TestJSONDelete(t *testing.T) {
+	cases := []struct {
+		body, path, expected string
+	}{
+		{`{"a": 1, "b": 2, "c": 3}`, "a", `{"b": 2, "c": 3}`},
+		{`{"a": 1, "b": 2, "c": 3}`, "c", `{"a": 1, "b": 2}`},
+		{`{"a": 1}`, "a", `{}`},
+		{`[{"a": 1, "b": 2}, {"b": 3, "a": 4}]`, "*.a", `[{"b": 2}, {"b": 3}]`},
+		{`{"a": [1, 2, 3]}`, "a.*", `{"a": []}`},
+		{`{"a": 1}`, "b", ``},
+	}
+
+	for _, c := range cases {
+		if out := jsonDelete([]byte(c.body), strings.Split(c.path, ".")); string(out) != c.expected {
+			t.Errorf("Delete %s in %s: expected %q, got %q", c.path, c.body, c.expected, out)
+		}
+	}
+}
+
+func TestJSONValue(t *testing.T) {
+	for value, expected := range map[string]string{
+		`1.5`:     `1.5`,
+		`true`:    `true`,
+		`"a"`:     `"a"`,
+		`{"a":1}`: `{"a":1}`,
+		`a@b.com`: `"a@b.com"`,
+		`{broken`: `"{broken"`,
+	} {
+		if out := jsonValue([]byte(value)); string(out) != expected {
+			t.Errorf("Expected %s, got %s", expected, out)
+		}
+	}
+}
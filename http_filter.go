@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/gor/proto"
+)
+
+// Expression based filtering, see --http-filter option. Example:
+//
+//	method in (GET, HEAD) and (path ~ "^/api" or header("X-Beta") == "1") and not host ~ "internal"
+//
+// Grammar:
+//
+//	expr       = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" expr ")" | condition
+//	condition  = operand [ op value | "in" "(" value { "," value } ")" ]
+//	operand    = method | path | host | url | body | status | latency | original_status | header(name) | param(name) | cookie(name) | json(path) | hash(operand)
+//	op         = "==" | "!=" | "~" | "!~" | "<" | "<=" | ">" | ">="
+//	value      = "quoted string" | bareword
+//
+// `status` and `latency` (round-trip time in milliseconds) are set only for responses, see --http-response-filter.
+// `original_status` is status of original response to the same request, taken from correlation store (--correlation-ttl),
+// so replayed responses can be compared with original ones.
+// Operand without comparison is true if its value is not empty. `~` matches regexp, `<`, `>` compare numbers.
+// `hash` returns FNV32-1A hash of value modulo 100, so `hash(header("X-User")) < 25` consistently takes 25% of users.
+// Expression compiled once, regexps compiled at parse time.
+
+// filterContext holds message which expression evaluated against
+type filterContext struct {
+	msg *proto.Message
+	// Decode compressed body for body and json operands
+	decodeBody bool
+	// Response round-trip time in milliseconds, taken from payload meta
+	latency []byte
+	// Request id from payload meta, used to find correlated payloads
+	id []byte
+
+	body    []byte
+	decoded bool
+}
+
+func (c *filterContext) Body() []byte {
+	if !c.decoded {
+		c.decoded = true
+		c.body = c.msg.Body()
+
+		if c.decodeBody && c.msg.IsEncoded() {
+			if body, err := c.msg.DecodeBody(); err == nil {
+				c.body = body
+			}
+		}
+	}
+
+	return c.body
+}
+
+type filterExpr interface {
+	eval(c *filterContext) bool
+}
+
+type filterOr struct{ left, right filterExpr }
+type filterAnd struct{ left, right filterExpr }
+type filterNot struct{ expr filterExpr }
+
+func (e *filterOr) eval(c *filterContext) bool  { return e.left.eval(c) || e.right.eval(c) }
+func (e *filterAnd) eval(c *filterContext) bool { return e.left.eval(c) && e.right.eval(c) }
+func (e *filterNot) eval(c *filterContext) bool { return !e.expr.eval(c) }
+
+// filterOperand extracts value from message
+type filterOperand struct {
+	name string
+	arg  []byte
+	path []string
+	// Argument of `hash` operand
+	inner *filterOperand
+}
+
+var filterOperands = map[string]bool{
+	"method": false, "path": false, "host": false, "url": false, "body": false, "status": false, "latency": false, "original_status": false,
+	"header": true, "param": true, "cookie": true, "json": true, "hash": true,
+}
+
+// value returns operand value, or nil if not found
+func (o *filterOperand) value(c *filterContext) []byte {
+	switch o.name {
+	case "method":
+		return c.msg.Method()
+	case "path":
+		return c.msg.Path()
+	case "host":
+		return c.msg.Host()
+	case "url":
+		return c.msg.URL()
+	case "body":
+		return c.Body()
+	case "status":
+		if bytes.HasPrefix(c.msg.Proto(), []byte("HTTP/")) {
+			return c.msg.Status()
+		}
+	case "latency":
+		return c.latency
+	case "original_status":
+		if store := Correlations; store != nil && c.id != nil {
+			if correlation, ok := store.Get(c.id); ok && correlation.Response != nil {
+				if response := proto.NewMessage(correlation.Response); bytes.HasPrefix(response.Proto(), []byte("HTTP/")) {
+					return response.Status()
+				}
+			}
+		}
+	case "header":
+		return c.msg.Header(o.arg)
+	case "param":
+		if value, start, _ := c.msg.PathParam(o.arg); start != -1 {
+			return value
+		}
+	case "cookie":
+		value, _, _ := cookieValue(c.msg.Header([]byte("Cookie")), o.arg)
+		return value
+	case "json":
+		body := c.Body()
+		if members := jsonMembers(body, o.path); len(members) > 0 {
+			value := body[members[0].valueStart:members[0].valueEnd]
+			if len(value) >= 2 && value[0] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			return value
+		}
+	case "hash":
+		if value := o.inner.value(c); len(value) > 0 {
+			hasher := fnv.New32a()
+			hasher.Write(value)
+
+			return []byte(strconv.Itoa(int(hasher.Sum32() % 100)))
+		}
+	}
+
+	return nil
+}
+
+// filterCondition compares operand value
+type filterCondition struct {
+	operand *filterOperand
+	// Empty if operand value checked for presence
+	op     string
+	values [][]byte
+	re     *regexp.Regexp
+	number float64
+}
+
+func (e *filterCondition) eval(c *filterContext) bool {
+	value := e.operand.value(c)
+
+	switch e.op {
+	case "":
+		return len(value) > 0
+	case "==":
+		return bytes.Equal(value, e.values[0])
+	case "!=":
+		return !bytes.Equal(value, e.values[0])
+	case "~":
+		return value != nil && e.re.Match(value)
+	case "!~":
+		return value == nil || !e.re.Match(value)
+	case "in":
+		for _, v := range e.values {
+			if bytes.Equal(value, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	n, err := strconv.ParseFloat(string(value), 64)
+	if err != nil {
+		return false
+	}
+
+	switch e.op {
+	case "<":
+		return n < e.number
+	case "<=":
+		return n <= e.number
+	case ">":
+		return n > e.number
+	case ">=":
+		return n >= e.number
+	}
+
+	return false
+}
+
+// Tokenizer
+const (
+	filterTokenEOF = iota
+	filterTokenWord
+	filterTokenString
+	filterTokenSymbol
+)
+
+type filterToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+// Symbols, longest first
+var filterSymbols = []string{"==", "!=", "!~", "<=", ">=", "~", "<", ">", "(", ")", ","}
+
+func isFilterWordChar(c byte) bool {
+	return c > ' ' && strings.IndexByte(`()",=!~<>`, c) == -1
+}
+
+func tokenizeFilter(source string) (tokens []filterToken, err error) {
+	for i := 0; i < len(source); {
+		c := source[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			var value []byte
+			start := i
+
+			for i++; ; i++ {
+				if i >= len(source) {
+					return nil, fmt.Errorf("unterminated string at %d", start)
+				}
+
+				// Only quote and backslash escaped, so regexps can be written as is: "^/api\.v1"
+				if source[i] == '\\' && i+1 < len(source) && (source[i+1] == '"' || source[i+1] == '\\') {
+					i++
+				} else if source[i] == '"' {
+					break
+				}
+
+				value = append(value, source[i])
+			}
+			i++
+
+			tokens = append(tokens, filterToken{filterTokenString, string(value), start})
+		case isFilterWordChar(c):
+			start := i
+			for i < len(source) && isFilterWordChar(source[i]) {
+				i++
+			}
+
+			tokens = append(tokens, filterToken{filterTokenWord, source[start:i], start})
+		default:
+			matched := false
+
+			for _, s := range filterSymbols {
+				if strings.HasPrefix(source[i:], s) {
+					tokens = append(tokens, filterToken{filterTokenSymbol, s, i})
+					i += len(s)
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, filterToken{filterTokenEOF, "", len(source)}), nil
+}
+
+// Parser
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != filterTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword checks if next token is given keyword, and consumes it
+func (p *filterParser) keyword(keyword string) bool {
+	if t := p.peek(); t.kind == filterTokenWord && strings.EqualFold(t.value, keyword) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *filterParser) expect(symbol string) error {
+	if t := p.next(); t.kind != filterTokenSymbol || t.value != symbol {
+		return p.unexpected(t, symbol)
+	}
+
+	return nil
+}
+
+func (p *filterParser) unexpected(t filterToken, expected string) error {
+	if t.kind == filterTokenEOF {
+		return fmt.Errorf("expected %s, got end of expression", expected)
+	}
+
+	return fmt.Errorf("expected %s, got %q at %d", expected, t.value, t.pos)
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &filterOr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &filterAnd{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.keyword("not") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &filterNot{expr}, nil
+	}
+
+	if t := p.peek(); t.kind == filterTokenSymbol && t.value == "(" {
+		p.next()
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		return expr, p.expect(")")
+	}
+
+	return p.parseCondition()
+}
+
+func (p *filterParser) parseOperand() (*filterOperand, error) {
+	t := p.next()
+	name := strings.ToLower(t.value)
+
+	hasArg, ok := filterOperands[name]
+	if t.kind != filterTokenWord || !ok {
+		return nil, p.unexpected(t, "method, path, host, url, body, status, latency, original_status, header, param, cookie, json or hash")
+	}
+
+	o := &filterOperand{name: name}
+	if !hasArg {
+		return o, nil
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	if name == "hash" {
+		inner, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		o.inner = inner
+	} else {
+		arg := p.next()
+		if arg.kind != filterTokenString && arg.kind != filterTokenWord {
+			return nil, p.unexpected(arg, name+" name")
+		}
+
+		o.arg = []byte(arg.value)
+		o.path = strings.Split(arg.value, ".")
+	}
+
+	return o, p.expect(")")
+}
+
+func (p *filterParser) parseValue() ([]byte, error) {
+	t := p.next()
+	if t.kind != filterTokenString && t.kind != filterTokenWord {
+		return nil, p.unexpected(t, "value")
+	}
+
+	return []byte(t.value), nil
+}
+
+func (p *filterParser) parseCondition() (filterExpr, error) {
+	operand, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &filterCondition{operand: operand}
+
+	if p.keyword("in") {
+		c.op = "in"
+
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			c.values = append(c.values, value)
+
+			if t := p.peek(); t.kind == filterTokenSymbol && t.value == "," {
+				p.next()
+				continue
+			}
+
+			return c, p.expect(")")
+		}
+	}
+
+	t := p.peek()
+	if t.kind != filterTokenSymbol || t.value == "(" || t.value == ")" || t.value == "," {
+		// Presence check
+		return c, nil
+	}
+
+	c.op = p.next().value
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.op {
+	case "~", "!~":
+		if c.re, err = regexp.Compile(string(value)); err != nil {
+			return nil, err
+		}
+	case "<", "<=", ">", ">=":
+		if c.number, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return nil, fmt.Errorf("expected number after %s, got %q", c.op, value)
+		}
+	default:
+		c.values = [][]byte{value}
+	}
+
+	return c, nil
+}
+
+// compileFilter parses filter expression
+func compileFilter(source string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != filterTokenEOF {
+		return nil, p.unexpected(t, "and, or or end of expression")
+	}
+
+	return expr, nil
+}
+
+// Handling of --http-filter option
+type httpFilter struct {
+	source string
+	expr   filterExpr
+}
+
+// HTTPFilters holds list of compiled filter expressions, message should match all of them
+type HTTPFilters []httpFilter
+
+func (h *HTTPFilters) String() string {
+	sources := make([]string, len(*h))
+	for i, f := range *h {
+		sources[i] = f.source
+	}
+
+	return fmt.Sprint(sources)
+}
+
+func (h *HTTPFilters) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("Expected filter expression, e.g. `method == GET and path ~ ^/api`")
+	}
+
+	expr, err := compileFilter(value)
+	if err != nil {
+		return fmt.Errorf("invalid filter expression: %v", err)
+	}
+
+	*h = append(*h, httpFilter{source: value, expr: expr})
+	return nil
+}
+
+// Match checks if message matches all filter expressions
+func (h HTTPFilters) Match(msg *proto.Message, decodeBody bool) bool {
+	return h.match(&filterContext{msg: msg, decodeBody: decodeBody})
+}
+
+// MatchPayload checks if payload, including meta header, matches all filter expressions.
+// For responses round-trip time from meta available as `latency` operand.
+func (h HTTPFilters) MatchPayload(payload []byte, decodeBody bool) bool {
+	c := &filterContext{msg: proto.NewMessage(payloadBody(payload)), decodeBody: decodeBody}
+
+	meta := payloadMeta(payload)
+	if len(meta) > 1 {
+		c.id = meta[1]
+	}
+
+	if len(meta) > 2 && !isRequestPayload(payload) {
+		if rtt, err := strconv.ParseInt(string(meta[2]), 10, 64); err == nil {
+			c.latency = []byte(strconv.FormatInt(rtt/int64(time.Millisecond), 10))
+		}
+	}
+
+	return h.match(c)
+}
+
+func (h HTTPFilters) match(c *filterContext) bool {
+	for _, f := range h {
+		if !f.expr.eval(c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/buger/gor/proto"
+)
+
+func TestHTTPFilterExpression(t *testing.T) {
+	request := []byte("POST /api/users?id=5&debug=1 HTTP/1.1\r\nHost: www.example.com\r\nX-Beta: 1\r\nCookie: sid=abc; lang=en\r\nContent-Length: 36\r\n\r\n{\"user\": {\"name\": \"john\", \"age\": 30}}")
+	response := []byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+
+	cases := []struct {
+		expr    string
+		payload []byte
+		match   bool
+	}{
+		{`method == POST`, request, true},
+		{`method in (GET, HEAD)`, request, false},
+		{`method in (GET,HEAD,POST)`, request, true},
+		{`path ~ "^/api"`, request, true},
+		{`path ~ ^/admin`, request, false},
+		{`path !~ "^/admin"`, request, true},
+		{`host ~ "example\.com$"`, request, true},
+		{`header("X-Beta") == "1"`, request, true},
+		{`header(x-beta) == 1`, request, true},
+		{`header("X-Missing")`, request, false},
+		{`not header("X-Missing")`, request, true},
+		{`header("X-Missing") != "1"`, request, true},
+		{`header("X-Missing") ~ ".*"`, request, false},
+		{`param("id") == 5 and param(debug)`, request, true},
+		{`cookie("sid") == abc and cookie("lang") != de`, request, true},
+		{`json("user.name") == "john" and json(user.age) >= 30`, request, true},
+		{`json("user.age") > 30`, request, false},
+		{`body ~ "john"`, request, true},
+		{`status`, request, false},
+		{`status >= 400 and status < 500`, response, true},
+		{`status == 200`, response, false},
+		{`hash(header("X-Beta")) < 100`, request, true},
+		{`hash(header("X-Missing")) < 100`, request, false},
+		{`method in (GET, HEAD) or (path ~ "^/api" and not host ~ "internal")`, request, true},
+		{`method == POST and (path ~ "^/admin" or header("X-Beta") == "2")`, request, false},
+		{`not not method == POST`, request, true},
+		{`NOT method == GET AND path ~ "users"`, request, true},
+		{`method == "PO\"ST"`, request, false},
+	}
+
+	for _, c := range cases {
+		filters := HTTPFilters{}
+		if err := filters.Set(c.expr); err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+
+		if match := filters.Match(proto.NewMessage(c.payload), false); match != c.match {
+			t.Errorf("%s: expected %v, got %v", c.expr, c.match, match)
+		}
+	}
+}
+
+func TestHTTPFilterExpressionErrors(t *testing.T) {
+	cases := []string{
+		``,
+		`method ==`,
+		`method == GET and`,
+		`(method == GET`,
+		`method == GET)`,
+		`unknown == 1`,
+		`header == 1`,
+		`header("X"`,
+		`path ~ "("`,
+		`status > abc`,
+		`method GET`,
+		`method in GET`,
+		`method == "GET`,
+		`method = GET`,
+	}
+
+	for _, c := range cases {
+		filters := HTTPFilters{}
+		if err := filters.Set(c); err == nil {
+			t.Errorf("%q: should fail", c)
+		}
+	}
+}
+
+func TestHTTPModifierFilterExpression(t *testing.T) {
+	filters := HTTPFilters{}
+	filters.Set(`method in (GET, HEAD) and (path ~ "^/api" or header("X-Beta") == "1")`)
+
+	headers := HTTPHeaders{}
+	headers.Set("X-Beta: 0")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{filters: filters, headers: headers})
+
+	if len(modifier.Rewrite([]byte("GET /api HTTP/1.1\r\n\r\n"))) == 0 {
+		t.Error("Request should pass")
+	}
+
+	if len(modifier.Rewrite([]byte("GET / HTTP/1.1\r\nX-Beta: 1\r\n\r\n"))) == 0 {
+		t.Error("Filter should be evaluated against original request")
+	}
+
+	if len(modifier.Rewrite([]byte("POST /api HTTP/1.1\r\n\r\n"))) != 0 {
+		t.Error("Request should be filtered")
+	}
+}
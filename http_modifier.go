@@ -15,27 +15,64 @@ func NewHTTPModifier(config *HTTPModifierConfig) *HTTPModifier {
 	// Optimization to skip modifier completely if we do not need it
 	if len(config.urlRegexp) == 0 &&
 		len(config.urlNegativeRegexp) == 0 &&
+		len(config.hostRegexp) == 0 &&
+		len(config.hostNegativeRegexp) == 0 &&
 		len(config.urlRewrite) == 0 &&
 		len(config.headerFilters) == 0 &&
+		len(config.headerOptionalFilters) == 0 &&
 		len(config.headerNegativeFilters) == 0 &&
 		len(config.headerHashFilters) == 0 &&
 		len(config.paramHashFilters) == 0 &&
+		len(config.metaFilters) == 0 &&
+		len(config.metaNegativeFilters) == 0 &&
 		len(config.params) == 0 &&
 		len(config.headers) == 0 &&
-		len(config.methods) == 0 {
+		len(config.deleteHeaders) == 0 &&
+		len(config.renameHeaders) == 0 &&
+		len(config.methods) == 0 &&
+		len(config.jsonFields) == 0 &&
+		len(config.jsonDelete) == 0 &&
+		len(config.formFields) == 0 &&
+		len(config.bodyRewrite) == 0 &&
+		config.replaceFiles == 0 &&
+		len(config.filters) == 0 {
 		return nil
 	}
 
 	return &HTTPModifier{config: config}
 }
 
+// FilterMeta checks optional payload meta fields, returns false if payload should be dropped
+func (m *HTTPModifier) FilterMeta(meta [][]byte) bool {
+	for _, f := range m.config.metaFilters {
+		if !f.match(payloadMetaValue(meta, string(f.name)), headerMustMatch) {
+			return false
+		}
+	}
+
+	for _, f := range m.config.metaNegativeFilters {
+		if !f.match(payloadMetaValue(meta, string(f.name)), headerMustNotMatch) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 	if !proto.IsHTTPPayload(payload) {
 		return payload
 	}
 
+	msg := proto.NewMessage(payload)
+
+	// Filter expressions evaluated against original request, before any modifications
+	if len(m.config.filters) > 0 && !m.config.filters.Match(msg, m.config.decodeBody) {
+		return
+	}
+
 	if len(m.config.methods) > 0 {
-		method := proto.Method(payload)
+		method := msg.Method()
 
 		matched := false
 
@@ -53,23 +90,24 @@ func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 
 	if len(m.config.headers) > 0 {
 		for _, header := range m.config.headers {
-			payload = proto.SetHeader(payload, []byte(header.Name), []byte(header.Value))
+			msg.SetHeader([]byte(header.Name), []byte(header.Value))
 		}
 	}
 
 	if len(m.config.params) > 0 {
 		for _, param := range m.config.params {
-			payload = proto.SetPathParam(payload, param.Name, param.Value)
+			msg.SetPathParam(param.Name, param.Value)
 		}
 	}
 
+	// URL filters matched against full url with host, and against path for compatibility with path-only patterns like `^/api`
 	if len(m.config.urlRegexp) > 0 {
-		path := proto.Path(payload)
+		path, url := msg.Path(), msg.URL()
 
 		matched := false
 
 		for _, f := range m.config.urlRegexp {
-			if f.regexp.Match(path) {
+			if f.regexp.Match(url) || f.regexp.Match(path) {
 				matched = true
 				break
 			}
@@ -81,38 +119,47 @@ func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 	}
 
 	if len(m.config.urlNegativeRegexp) > 0 {
-		path := proto.Path(payload)
+		path, url := msg.Path(), msg.URL()
 
 		for _, f := range m.config.urlNegativeRegexp {
-			if f.regexp.Match(path) {
+			if f.regexp.Match(url) || f.regexp.Match(path) {
 				return
 			}
 		}
 	}
 
-	if len(m.config.headerFilters) > 0 {
-		for _, f := range m.config.headerFilters {
-			value := proto.Header(payload, f.name)
+	if len(m.config.hostRegexp) > 0 || len(m.config.hostNegativeRegexp) > 0 {
+		host := hostname(msg.Host())
 
-			if len(value) > 0 && !f.regexp.Match(value) {
-				return
+		matched := len(m.config.hostRegexp) == 0
+
+		for _, f := range m.config.hostRegexp {
+			if f.regexp.Match(host) {
+				matched = true
+				break
 			}
 		}
-	}
 
-	if len(m.config.headerNegativeFilters) > 0 {
-		for _, f := range m.config.headerNegativeFilters {
-			value := proto.Header(payload, f.name)
+		if !matched {
+			return
+		}
 
-			if len(value) > 0 && f.regexp.Match(value) {
+		for _, f := range m.config.hostNegativeRegexp {
+			if f.regexp.Match(host) {
 				return
 			}
 		}
 	}
 
+	if !matchHeaderFilters(msg, m.config.headerFilters, headerMustMatch) ||
+		!matchHeaderFilters(msg, m.config.headerOptionalFilters, headerMatchIfPresent) ||
+		!matchHeaderFilters(msg, m.config.headerNegativeFilters, headerMustNotMatch) {
+		return
+	}
+
 	if len(m.config.headerHashFilters) > 0 {
 		for _, f := range m.config.headerHashFilters {
-			value := proto.Header(payload, f.name)
+			value := msg.Header(f.name)
 
 			if len(value) > 0 {
 				hasher := fnv.New32a()
@@ -127,7 +174,7 @@ func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 
 	if len(m.config.paramHashFilters) > 0 {
 		for _, f := range m.config.paramHashFilters {
-			value, s, _ := proto.PathParam(payload, f.name)
+			value, s, _ := msg.PathParam(f.name)
 
 			if s != -1 {
 				hasher := fnv.New32a()
@@ -140,18 +187,150 @@ func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 		}
 	}
 
+	// Deleted and renamed headers should stay visible to filters above
+	for _, name := range m.config.deleteHeaders {
+		msg.DeleteHeader(name)
+	}
+
+	for _, r := range m.config.renameHeaders {
+		msg.RenameHeader(r.from, r.to)
+	}
+
 	if len(m.config.urlRewrite) > 0 {
-		path := proto.Path(payload)
+		path := msg.Path()
 
 		for _, f := range m.config.urlRewrite {
 			if f.src.Match(path) {
-				path = f.src.ReplaceAll(path, f.target)
-				payload = proto.SetPath(payload, path)
+				msg.SetPath(f.src.ReplaceAll(path, f.target))
 
 				break
 			}
 		}
 	}
 
-	return payload
+	if len(m.config.jsonFields) > 0 || len(m.config.jsonDelete) > 0 || len(m.config.formFields) > 0 || len(m.config.bodyRewrite) > 0 || m.config.replaceFiles > 0 {
+		m.rewriteBody(msg)
+	}
+
+	return msg.Bytes()
+}
+
+// hostname strips port from host, IPv6 addresses returned without brackets
+func hostname(host []byte) []byte {
+	if len(host) > 0 && host[0] == '[' {
+		if end := bytes.IndexByte(host, ']'); end != -1 {
+			return host[1:end]
+		}
+	}
+
+	if i := bytes.LastIndexByte(host, ':'); i != -1 && bytes.IndexByte(host, ':') == i {
+		return host[:i]
+	}
+
+	return host
+}
+
+// matchHeaderFilters checks that message headers pass all filters in given mode
+func matchHeaderFilters(msg *proto.Message, filters HTTPHeaderFilters, mode int) bool {
+	for _, f := range filters {
+		if !f.match(msg.Header(f.name), mode) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rewriteBody applies JSON, form and regexp body modifiers, Content-Length updated if body changed.
+// Bodies with chunked Transfer-Encoding are not modified.
+// If body decoding enabled, modifiers applied to decoded body, and result encoded back using same Content-Encoding.
+func (m *HTTPModifier) rewriteBody(msg *proto.Message) {
+	// Chunk sizes would not match rewritten body
+	if msg.IsChunked() {
+		Debug("[HTTP-MODIFIER] Can't rewrite chunked body, skipping")
+		return
+	}
+
+	body := msg.Body()
+	changed := false
+
+	decoded := m.config.decodeBody && msg.IsEncoded()
+	if decoded {
+		var err error
+		if body, err = msg.DecodeBody(); err != nil {
+			Debug("[HTTP-MODIFIER] Can't decode body:", err)
+			return
+		}
+	}
+
+	for _, f := range m.config.jsonFields {
+		if b := jsonSet(body, f.path, f.value); b != nil {
+			body, changed = b, true
+		}
+	}
+
+	for _, path := range m.config.jsonDelete {
+		if b := jsonDelete(body, path); b != nil {
+			body, changed = b, true
+		}
+	}
+
+	if boundary := msg.MultipartBoundary(); boundary != nil {
+		if len(m.config.formFields) > 0 || m.config.replaceFiles > 0 {
+			if b := m.rewriteMultipart(body, boundary); b != nil {
+				body, changed = b, true
+			}
+		}
+	} else if len(m.config.formFields) > 0 && bytes.HasPrefix(msg.Header([]byte("Content-Type")), []byte("application/x-www-form-urlencoded")) {
+		for _, f := range m.config.formFields {
+			body, changed = formSet(body, f.Name, f.Value), true
+		}
+	}
+
+	for _, f := range m.config.bodyRewrite {
+		if f.src.Match(body) {
+			body, changed = f.src.ReplaceAll(body, f.target), true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	if !decoded {
+		msg.SetBody(body)
+		return
+	}
+
+	if err := msg.EncodeBody(body); err != nil {
+		Debug("[HTTP-MODIFIER] Can't encode body:", err)
+	}
+}
+
+// rewriteMultipart sets form fields and replaces large files of `multipart/form-data` body.
+// Returns nil if body can't be parsed, or nothing was changed.
+func (m *HTTPModifier) rewriteMultipart(body, boundary []byte) []byte {
+	multipart, err := proto.ParseMultipart(body, boundary)
+	if err != nil {
+		Debug("[HTTP-MODIFIER] Can't parse multipart body:", err)
+		return nil
+	}
+
+	changed := false
+
+	for _, f := range m.config.formFields {
+		if multipart.SetField(f.Name, f.Value) {
+			changed = true
+		}
+	}
+
+	if m.config.replaceFiles > 0 && multipart.ReplaceFiles(int(m.config.replaceFiles)) > 0 {
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return multipart.Bytes()
 }
@@ -12,25 +12,68 @@ import (
 type HTTPModifierConfig struct {
 	urlNegativeRegexp     HTTPUrlRegexp
 	urlRegexp             HTTPUrlRegexp
+	hostRegexp            HTTPUrlRegexp
+	hostNegativeRegexp    HTTPUrlRegexp
 	urlRewrite            UrlRewriteMap
+	// Header filters by mode, see headerFilter.match
 	headerFilters         HTTPHeaderFilters
+	headerOptionalFilters HTTPHeaderFilters
 	headerNegativeFilters HTTPHeaderFilters
 	headerHashFilters     HTTPHashFilters
 	paramHashFilters      HTTPHashFilters
-
-	params  HTTPParams
-	headers HTTPHeaders
-	methods HTTPMethods
+	metaFilters           HTTPHeaderFilters
+	metaNegativeFilters   HTTPHeaderFilters
+	filters               HTTPFilters
+
+	params        HTTPParams
+	headers       HTTPHeaders
+	deleteHeaders HTTPHeaderNames
+	renameHeaders HTTPHeaderRenames
+	methods       HTTPMethods
+
+	jsonFields  HTTPJSONFields
+	jsonDelete  HTTPJSONPaths
+	formFields  HTTPParams
+	bodyRewrite UrlRewriteMap
+	// Minimum size of multipart files replaced with placeholders, 0 disables replacement
+	replaceFiles DataSize
+	// Apply body modifiers to decoded body of gzip, deflate or br encoded payloads
+	decodeBody bool
 }
 
 //
-// Handling of --http-allow-header, --http-disallow-header options
+// Handling of --http-allow-header, --http-allow-header-if-present, --http-disallow-header options
+// Also used by --http-allow-meta, --http-disallow-meta options
 //
 type headerFilter struct {
 	name   []byte
 	regexp *regexp.Regexp
 }
 
+// Header filter modes
+const (
+	// Header should be present and match, --http-allow-header
+	headerMustMatch = iota
+	// Header should match if present, requests without header pass, --http-allow-header-if-present
+	headerMatchIfPresent
+	// Header should be absent or not match, --http-disallow-header
+	headerMustNotMatch
+)
+
+// match checks header value according to filter mode, value is nil if header not present
+func (f *headerFilter) match(value []byte, mode int) bool {
+	switch mode {
+	case headerMustMatch:
+		return value != nil && f.regexp.Match(value)
+	case headerMatchIfPresent:
+		return value == nil || f.regexp.Match(value)
+	case headerMustNotMatch:
+		return value == nil || !f.regexp.Match(value)
+	}
+
+	return false
+}
+
 // HTTPHeaderFilters holds list of headers and their regexps
 type HTTPHeaderFilters []headerFilter
 
@@ -43,12 +86,13 @@ func (h *HTTPHeaderFilters) Set(value string) error {
 	if len(valArr) < 2 {
 		return errors.New("need both header and value, colon-delimited (ex. user_id:^169$).")
 	}
-	r, err := regexp.Compile(valArr[1])
+	// Space after colon is optional, like in header itself: `User-Agent: Gor`
+	r, err := regexp.Compile(strings.TrimLeft(valArr[1], " \t"))
 	if err != nil {
 		return err
 	}
 
-	*h = append(*h, headerFilter{name: []byte(valArr[0]), regexp: r})
+	*h = append(*h, headerFilter{name: []byte(strings.TrimSpace(valArr[0])), regexp: r})
 
 	return nil
 }
@@ -194,7 +238,8 @@ func (r *UrlRewriteMap) Set(value string) error {
 }
 
 //
-// Handling of --http-allow-url option
+// Handling of --http-allow-url, --http-disallow-url options
+// Also used by --http-allow-host, --http-disallow-host options
 //
 type urlRegexp struct {
 	regexp *regexp.Regexp
@@ -213,3 +258,95 @@ func (r *HTTPUrlRegexp) Set(value string) error {
 
 	return err
 }
+
+//
+// Handling of --http-set-json option
+//
+type jsonField struct {
+	path  []string
+	value []byte
+}
+
+// HTTPJSONFields holds list of JSON paths and their new values
+type HTTPJSONFields []jsonField
+
+func (h *HTTPJSONFields) String() string {
+	return fmt.Sprint(*h)
+}
+
+func (h *HTTPJSONFields) Set(value string) error {
+	v := strings.SplitN(value, "=", 2)
+	if len(v) != 2 || strings.TrimSpace(v[0]) == "" {
+		return errors.New("Expected `path=value`, e.g. user.email=test@example.com")
+	}
+
+	*h = append(*h, jsonField{
+		path:  strings.Split(strings.TrimSpace(v[0]), "."),
+		value: jsonValue([]byte(strings.TrimSpace(v[1]))),
+	})
+	return nil
+}
+
+//
+// Handling of --http-delete-json option
+//
+type HTTPJSONPaths [][]string
+
+func (h *HTTPJSONPaths) String() string {
+	return fmt.Sprint(*h)
+}
+
+func (h *HTTPJSONPaths) Set(value string) error {
+	if value == "" {
+		return errors.New("Expected JSON path, e.g. user.password")
+	}
+
+	*h = append(*h, strings.Split(value, "."))
+	return nil
+}
+
+//
+// Handling of --http-delete-header option
+//
+type HTTPHeaderNames [][]byte
+
+func (h *HTTPHeaderNames) String() string {
+	return fmt.Sprint(*h)
+}
+
+func (h *HTTPHeaderNames) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return errors.New("Expected header name")
+	}
+
+	*h = append(*h, []byte(value))
+	return nil
+}
+
+//
+// Handling of --http-rename-header option
+//
+type headerRename struct {
+	from []byte
+	to   []byte
+}
+
+type HTTPHeaderRenames []headerRename
+
+func (h *HTTPHeaderRenames) String() string {
+	return fmt.Sprint(*h)
+}
+
+func (h *HTTPHeaderRenames) Set(value string) error {
+	v := strings.SplitN(value, ":", 2)
+	if len(v) != 2 || strings.TrimSpace(v[0]) == "" || strings.TrimSpace(v[1]) == "" {
+		return errors.New("Expected `From:To` header names")
+	}
+
+	*h = append(*h, headerRename{
+		[]byte(strings.TrimSpace(v[0])),
+		[]byte(strings.TrimSpace(v[1])),
+	})
+	return nil
+}
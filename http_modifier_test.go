@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"github.com/buger/gor/proto"
+	"strconv"
 	"testing"
 )
 
@@ -66,6 +67,114 @@ func TestHTTPModifierHeaderNegativeFilters(t *testing.T) {
 	}
 }
 
+func TestHTTPModifierHeaderFilterModes(t *testing.T) {
+	withHeader := []byte("GET / HTTP/1.1\r\nUser-Agent: Replayed by Gor\r\nX-Empty: \r\n\r\n")
+	withOtherHeader := []byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\n\r\n")
+	withoutHeader := []byte("GET / HTTP/1.1\r\nHost: www.w3.org\r\n\r\n")
+
+	cases := []struct {
+		mode    string
+		filter  string
+		payload []byte
+		pass    bool
+	}{
+		{"allow", "User-Agent: Replayed by Gor", withHeader, true},
+		{"allow", "user-agent:^Replayed", withOtherHeader, false},
+		{"allow", "User-Agent:^Replayed", withoutHeader, false},
+		{"allow", "X-Empty:^$", withHeader, true},
+		{"allow", "X-Empty:^$", withoutHeader, false},
+		{"allow-if-present", "User-Agent:^Replayed", withHeader, true},
+		{"allow-if-present", "User-Agent:^Replayed", withOtherHeader, false},
+		{"allow-if-present", "User-Agent:^Replayed", withoutHeader, true},
+		{"disallow", "User-Agent: Replayed by Gor", withHeader, false},
+		{"disallow", "User-Agent:^Replayed", withOtherHeader, true},
+		{"disallow", "User-Agent:^Replayed", withoutHeader, true},
+	}
+
+	for _, c := range cases {
+		filters := HTTPHeaderFilters{}
+		if err := filters.Set(c.filter); err != nil {
+			t.Fatal(err)
+		}
+
+		config := &HTTPModifierConfig{}
+		switch c.mode {
+		case "allow":
+			config.headerFilters = filters
+		case "allow-if-present":
+			config.headerOptionalFilters = filters
+		case "disallow":
+			config.headerNegativeFilters = filters
+		}
+
+		if pass := len(NewHTTPModifier(config).Rewrite(c.payload)) > 0; pass != c.pass {
+			t.Errorf("%s %q on %q: expected %v, got %v", c.mode, c.filter, c.payload, c.pass, pass)
+		}
+	}
+}
+
+func TestHTTPModifierHostFilters(t *testing.T) {
+	api := []byte("GET /users HTTP/1.1\r\nHost: api.example.com:8080\r\n\r\n")
+	proxy := []byte("GET http://www.example.com/users HTTP/1.0\r\n\r\n")
+	internal := []byte("GET /users HTTP/1.1\r\nHost: internal.example.com\r\n\r\n")
+
+	allow := HTTPUrlRegexp{}
+	allow.Set(`^(api|www)\.example\.com$`)
+
+	disallow := HTTPUrlRegexp{}
+	disallow.Set(`^www\.`)
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{hostRegexp: allow})
+
+	if len(modifier.Rewrite(api)) == 0 || len(modifier.Rewrite(proxy)) == 0 {
+		t.Error("Requests to allowed hosts should pass, port ignored")
+	}
+
+	if len(modifier.Rewrite(internal)) != 0 {
+		t.Error("Requests to other hosts should be dropped")
+	}
+
+	modifier = NewHTTPModifier(&HTTPModifierConfig{hostRegexp: allow, hostNegativeRegexp: disallow})
+
+	if len(modifier.Rewrite(api)) == 0 || len(modifier.Rewrite(proxy)) != 0 {
+		t.Error("Requests to disallowed hosts should be dropped")
+	}
+
+	for host, expected := range map[string]string{"a.com": "a.com", "a.com:80": "a.com", "[::1]:80": "::1", "::1": "::1", "": ""} {
+		if got := string(hostname([]byte(host))); got != expected {
+			t.Errorf("%q: expected %q, got %q", host, expected, got)
+		}
+	}
+}
+
+func TestHTTPModifierFullURLFilters(t *testing.T) {
+	filters := HTTPUrlRegexp{}
+	filters.Set(`^www\.example\.com/api`)
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{urlRegexp: filters})
+
+	if len(modifier.Rewrite([]byte("GET /api/users HTTP/1.1\r\nHost: www.example.com\r\n\r\n"))) == 0 {
+		t.Error("Should match url with host")
+	}
+
+	if len(modifier.Rewrite([]byte("GET http://www.example.com/api HTTP/1.0\r\n\r\n"))) == 0 {
+		t.Error("Should match absolute url")
+	}
+
+	if len(modifier.Rewrite([]byte("GET /api/users HTTP/1.1\r\nHost: api.example.com\r\n\r\n"))) != 0 {
+		t.Error("Should not match other host")
+	}
+
+	filters = HTTPUrlRegexp{}
+	filters.Set(`^/api`)
+
+	modifier = NewHTTPModifier(&HTTPModifierConfig{urlNegativeRegexp: filters})
+
+	if len(modifier.Rewrite([]byte("GET /api/users HTTP/1.1\r\nHost: www.example.com\r\n\r\n"))) != 0 {
+		t.Error("Path-only patterns should still match")
+	}
+}
+
 func TestHTTPModifierURLRewrite(t *testing.T) {
 	var url, newURL []byte
 
@@ -245,3 +354,213 @@ func TestHTTPModifierSetParam(t *testing.T) {
 		t.Error("Should override param", string(payload))
 	}
 }
+
+func TestHTTPModifierMetaFilters(t *testing.T) {
+	filters := HTTPHeaderFilters{}
+	filters.Set("src:^10\\.0\\.")
+
+	negativeFilters := HTTPHeaderFilters{}
+	negativeFilters.Set("input:^http$")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		metaFilters:         filters,
+		metaNegativeFilters: negativeFilters,
+	})
+
+	cases := map[string]bool{
+		"1 a 1 src=10.0.0.1:5000 input=raw":    true,
+		"1 a 1 src=10.0.0.1:5000 input=http":   false,
+		"1 a 1 src=192.168.0.1:5000 input=raw": false,
+		// Field required by allow filter is missing
+		"1 a 1": false,
+	}
+
+	for header, pass := range cases {
+		if modifier.FilterMeta(payloadMeta([]byte(header+"\n"))) != pass {
+			t.Error("Wrong filtering result for:", header)
+		}
+	}
+}
+
+func TestHTTPModifierBody(t *testing.T) {
+	jsonFields := HTTPJSONFields{}
+	jsonFields.Set("users.*.email=test@example.com")
+	jsonFields.Set("meta.replayed=true")
+
+	jsonDelete := HTTPJSONPaths{}
+	jsonDelete.Set("users.*.password")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		jsonFields: jsonFields,
+		jsonDelete: jsonDelete,
+	})
+
+	payload := []byte("POST /post HTTP/1.1\r\nContent-Length: 99\r\n\r\n{\"users\": [{\"email\": \"a@b.com\", \"password\": \"1\"}, {\"email\": \"c@d.com\", \"password\": \"2\"}], \"meta\": {}}")
+	expected := "{\"users\": [{\"email\": \"test@example.com\"}, {\"email\": \"test@example.com\"}], \"meta\": {\"replayed\":true}}"
+
+	payload = modifier.Rewrite(payload)
+	if string(proto.Body(payload)) != expected {
+		t.Errorf("Wrong JSON body: %q", proto.Body(payload))
+	}
+
+	if string(proto.Header(payload, []byte("Content-Length"))) != strconv.Itoa(len(expected)) {
+		t.Error("Content-Length should be updated", string(proto.Header(payload, []byte("Content-Length"))))
+	}
+
+	formFields := HTTPParams{}
+	formFields.Set("user_id=1 2")
+
+	rewrite := UrlRewriteMap{}
+	rewrite.Set("[a-z]+@example\\.com:hidden")
+
+	modifier = NewHTTPModifier(&HTTPModifierConfig{
+		formFields:  formFields,
+		bodyRewrite: rewrite,
+	})
+
+	payload = []byte("POST /post HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 32\r\n\r\nemail=john@example.com&user_id=5")
+	if payload = modifier.Rewrite(payload); string(proto.Body(payload)) != "email=hidden&user_id=1+2" {
+		t.Errorf("Wrong form body: %q", proto.Body(payload))
+	}
+
+	// Form fields set only for form requests
+	payload = []byte("POST /post HTTP/1.1\r\nContent-Length: 9\r\n\r\nuser_id=5")
+	if payload = modifier.Rewrite(payload); string(proto.Body(payload)) != "user_id=5" {
+		t.Errorf("Wrong body: %q", proto.Body(payload))
+	}
+}
+
+func TestHTTPModifierDeleteRenameHeader(t *testing.T) {
+	deleteHeaders := HTTPHeaderNames{}
+	deleteHeaders.Set("x-forwarded-for")
+
+	renameHeaders := HTTPHeaderRenames{}
+	renameHeaders.Set("Authorization: X-Original-Authorization")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		deleteHeaders: deleteHeaders,
+		renameHeaders: renameHeaders,
+	})
+
+	payload := []byte("GET / HTTP/1.1\r\nX-Forwarded-For: 1.1.1.1\r\nAUTHORIZATION: Bearer 1\r\n\r\n")
+	payloadAfter := []byte("GET / HTTP/1.1\r\nX-Original-Authorization: Bearer 1\r\n\r\n")
+
+	if payload = modifier.Rewrite(payload); !bytes.Equal(payload, payloadAfter) {
+		t.Error("Should delete and rename headers", string(payload))
+	}
+}
+
+func TestHTTPModifierDeleteHeaderWithFilters(t *testing.T) {
+	deleteHeaders := HTTPHeaderNames{}
+	deleteHeaders.Set("Authorization")
+
+	headerFilters := HTTPHeaderFilters{}
+	headerFilters.Set("Authorization:^Bearer")
+
+	renameHeaders := HTTPHeaderRenames{}
+	renameHeaders.Set("X-User: X-Original-User")
+
+	headerHashFilters := HTTPHashFilters{}
+	headerHashFilters.Set("X-User:0%")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		deleteHeaders:     deleteHeaders,
+		headerFilters:     headerFilters,
+		renameHeaders:     renameHeaders,
+		headerHashFilters: headerHashFilters,
+	})
+
+	payload := []byte("GET / HTTP/1.1\r\nAuthorization: Bearer 1\r\n\r\n")
+	if out := modifier.Rewrite(payload); string(out) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("Filters should see headers before they deleted: %q", out)
+	}
+
+	// Hash limiter taking 0% should drop request with renamed header, as it sees original value
+	if out := modifier.Rewrite([]byte("GET / HTTP/1.1\r\nAuthorization: Bearer 1\r\nX-User: 1\r\n\r\n")); len(out) != 0 {
+		t.Errorf("Hash limiter should see headers before they renamed: %q", out)
+	}
+}
+
+func TestHTTPModifierDecodeBody(t *testing.T) {
+	jsonDelete := HTTPJSONPaths{}
+	jsonDelete.Set("password")
+
+	body, _ := proto.Encode([]byte("gzip"), []byte(`{"user": "a", "password": "1"}`))
+	payload := []byte("POST /post HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body))
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{jsonDelete: jsonDelete})
+	if out := modifier.Rewrite(payload); !bytes.Equal(out, payload) {
+		t.Error("Encoded body should not be modified without decoding")
+	}
+
+	modifier = NewHTTPModifier(&HTTPModifierConfig{jsonDelete: jsonDelete, decodeBody: true})
+	out := modifier.Rewrite(payload)
+
+	if decoded, err := proto.DecodeBody(out); err != nil || string(decoded) != `{"user": "a"}` {
+		t.Errorf("Wrong decoded body: %q %v", decoded, err)
+	}
+
+	if string(proto.Header(out, []byte("Content-Length"))) != strconv.Itoa(len(proto.Body(out))) {
+		t.Error("Content-Length should match encoded body")
+	}
+}
+
+func TestHTTPModifierMultipart(t *testing.T) {
+	formFields := HTTPParams{}
+	formFields.Set("user_id=1")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		formFields:   formFields,
+		replaceFiles: 4,
+	})
+
+	body := "--xyz\r\nContent-Disposition: form-data; name=\"user_id\"\r\n\r\n5\r\n--xyz\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\nsecret\r\n--xyz--\r\n"
+	payload := []byte("POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=xyz\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body)
+
+	payload = modifier.Rewrite(payload)
+	multipart, err := proto.ParseMultipart(proto.Body(payload), []byte("xyz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _ := multipart.Field([]byte("user_id")); string(value) != "1" {
+		t.Error("Field should be updated", string(value))
+	}
+
+	if file := multipart.Parts[1].Body; len(file) != len("secret") || bytes.Equal(file, []byte("secret")) {
+		t.Errorf("File should be replaced with placeholder: %q", file)
+	}
+
+	if string(proto.Header(payload, []byte("Content-Length"))) != strconv.Itoa(len(body)) {
+		t.Error("Content-Length should be the same", string(proto.Header(payload, []byte("Content-Length"))))
+	}
+}
+
+func TestHTTPModifierMultipartUnchanged(t *testing.T) {
+	formFields := HTTPParams{}
+	formFields.Set("user_id=5")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{
+		formFields:   formFields,
+		replaceFiles: 100,
+	})
+
+	body := "--xyz\r\nContent-Disposition: form-data; name=\"user_id\"\r\n\r\n5\r\n--xyz\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\nsecret\r\n--xyz--\r\n"
+	payload := []byte("POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=xyz\r\n\r\n" + body)
+
+	if out := modifier.Rewrite(payload); &out[0] != &payload[0] {
+		t.Errorf("Payload should be returned as is if nothing changed: %q", out)
+	}
+}
+
+func TestHTTPModifierChunkedBody(t *testing.T) {
+	bodyRewrite := UrlRewriteMap{}
+	bodyRewrite.Set("secret:public-value")
+
+	modifier := NewHTTPModifier(&HTTPModifierConfig{bodyRewrite: bodyRewrite})
+
+	payload := []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecret\r\n0\r\n\r\n")
+	if out := modifier.Rewrite(payload); !bytes.Equal(out, payload) {
+		t.Errorf("Chunked body should not be modified: %q", out)
+	}
+}
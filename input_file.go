@@ -2,26 +2,50 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
 )
 
+// Raw input emits request when it is completed, not when it started, so recording is sorted by time only approximately.
+// When time range replayed, file is read this much before and after the range, so out of order requests are not lost.
+const fileInputOrderGrace = time.Minute
+
+// FileInputConfig holds time range which should be replayed
+type FileInputConfig struct {
+	from TimeValue
+	to   TimeValue
+}
+
 // FileInput can read requests generated by FileOutput
+// Files compressed with gzip or zstd are detected automatically
 type FileInput struct {
 	data        chan []byte
 	path        string
 	file        *os.File
+	decoder     *payloadDecoder
 	speedFactor float64
+	config      *FileInputConfig
 }
 
 // NewFileInput constructor for FileInput. Accepts file path as argument.
-func NewFileInput(path string) (i *FileInput) {
+func NewFileInput(path string, config *FileInputConfig) (i *FileInput) {
 	i = new(FileInput)
 	i.data = make(chan []byte)
 	i.path = path
 	i.speedFactor = 1
+	i.config = config
 	i.init(path)
 
 	go i.emit()
@@ -37,6 +61,51 @@ func (i *FileInput) init(path string) {
 	}
 
 	i.file = file
+
+	var reader io.Reader = file
+
+	// If recording have index, jump straight to the requested time range
+	if !i.config.from.IsZero() {
+		if index, err := readFileIndex(fileIndexPath(path)); err == nil {
+			offset := fileIndexOffset(index, i.config.from.Add(-fileInputOrderGrace).UnixNano())
+			// Stream header located only at start of the file
+			header := readFramingHeader(file)
+
+			if _, err := file.Seek(offset, os.SEEK_SET); err != nil {
+				log.Fatal(i, "Cannot seek file %q. Error: %s", path, err)
+			}
+
+			if header != nil && offset > 0 {
+				reader = io.MultiReader(bytes.NewReader(header), file)
+			}
+
+			Debug("[INPUT-FILE] Using index, starting from offset:", offset)
+		}
+	}
+
+	if reader, err = decompressReader(reader); err != nil {
+		log.Fatal(i, "Cannot read compressed file %q. Error: %s", path, err)
+	}
+
+	if i.decoder, err = newPayloadDecoder(reader); err != nil {
+		log.Fatal(i, "Cannot read file %q. Error: %s", path, err)
+	}
+}
+
+// decompressReader checks stream magic bytes and wraps reader into decompressor if needed
+func decompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	// Peek returns error if stream shorter then magic, in this case it is not compressed
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstd.NewReader(br)
+	default:
+		return br, nil
+	}
 }
 
 func (i *FileInput) Read(data []byte) (int, error) {
@@ -53,31 +122,53 @@ func (i *FileInput) String() string {
 func (i *FileInput) emit() {
 	var lastTime int64
 
-	// reader := bufio.NewReader(conn)
-	scanner := bufio.NewScanner(i.file)
-	scanner.Split(payloadScanner)
+	from, to := i.config.from.UnixNano(), i.config.to.UnixNano()
+	// Responses follow their requests, so they skipped together with them
+	skip := !i.config.from.IsZero()
+
+	for {
+		buf, err := i.decoder.Decode()
+
+		if err != nil {
+			if err != io.EOF {
+				log.Println("FileInput: error while reading file:", err)
+			}
+			break
+		}
 
-	for scanner.Scan() {
-		buf := scanner.Bytes()
 		meta := payloadMeta(buf)
 
 		if meta[0][0] == RequestPayload {
 			ts, _ := strconv.ParseInt(string(meta[2]), 10, 64)
 
-			if lastTime != 0 {
-				timeDiff := ts - lastTime
+			// Recording is sorted by time only approximately, so stop reading only after grace window
+			if !i.config.to.IsZero() && ts > to+int64(fileInputOrderGrace) {
+				break
+			}
+
+			skip = (!i.config.from.IsZero() && ts < from) || (!i.config.to.IsZero() && ts > to)
 
-				if i.speedFactor != 1 {
-					timeDiff = int64(float64(timeDiff) / i.speedFactor)
+			// Requests which came out of order are emitted without delay
+			if !skip && ts > lastTime {
+				if lastTime != 0 {
+					timeDiff := ts - lastTime
+
+					if i.speedFactor != 1 {
+						timeDiff = int64(float64(timeDiff) / i.speedFactor)
+					}
+
+					time.Sleep(time.Duration(timeDiff))
 				}
 
-				time.Sleep(time.Duration(timeDiff))
+				lastTime = ts
 			}
+		}
 
-			lastTime = ts
+		if skip {
+			continue
 		}
 
-		// scanner returs only pointer, so to remove data-race we have to allocate new array
+		// decoder returns only pointer, so to remove data-race we have to allocate new array
 		newBuf := make([]byte, len(buf))
 		copy(newBuf, buf)
 
@@ -28,20 +28,22 @@ func NewHTTPInput(address string) (i *HTTPInput) {
 
 func (i *HTTPInput) Read(data []byte) (int, error) {
 	buf := <-i.data
+	copy(data, buf)
 
-	header := payloadHeader(RequestPayload, uuid(), time.Now().UnixNano())
-
-	copy(data[0:len(header)], header)
-	copy(data[len(header):], buf)
-
-	return len(buf) + len(header), nil
+	return len(buf), nil
 }
 
 func (i *HTTPInput) handler(w http.ResponseWriter, r *http.Request) {
 	r.URL.Scheme = "http"
 	r.URL.Host = i.listener.Addr().String()
 
+	header := payloadHeader(RequestPayload, uuid(), time.Now().UnixNano(),
+		metaField(metaSrc, r.RemoteAddr),
+		metaField(metaInput, "http"),
+	)
+
 	buf, _ := httputil.DumpRequestOut(r, true)
+	buf = append(header, buf...)
 	http.Error(w, http.StatusText(200), 200)
 
 	select {
@@ -4,6 +4,7 @@ import (
 	raw "github.com/buger/gor/raw_socket_listener"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -36,10 +37,18 @@ func (i *RAWInput) Read(data []byte) (int, error) {
 
 	var header []byte
 
+	fields := []string{
+		metaField(metaSrc, msg.SrcAddr),
+		metaField(metaDst, msg.DstAddr),
+		metaField(metaConn, msg.ConnID),
+		metaField(metaSeq, strconv.FormatUint(uint64(msg.Seq()), 10)),
+		metaField(metaInput, "raw"),
+	}
+
 	if msg.IsIncoming {
-		header = payloadHeader(RequestPayload, msg.UUID(), msg.Start.UnixNano())
+		header = payloadHeader(RequestPayload, msg.UUID(), msg.Start.UnixNano(), fields...)
 	} else {
-		header = payloadHeader(ResponsePayload, msg.UUID(), msg.End.UnixNano()-msg.RequestStart.UnixNano())
+		header = payloadHeader(ResponsePayload, msg.UUID(), msg.End.UnixNano()-msg.RequestStart.UnixNano(), fields...)
 	}
 
 	copy(data[0:len(header)], header)
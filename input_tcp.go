@@ -1,8 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -58,16 +58,29 @@ func (i *TCPInput) listen(address string) {
 func (i *TCPInput) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	reader := bufio.NewReader(conn)
-	scanner := bufio.NewScanner(reader)
-	scanner.Split(payloadScanner)
+	// Framing format detected for each connection, so both old and new Gor instances can send data
+	decoder, err := newPayloadDecoder(conn)
 
-	for scanner.Scan() {
-		i.data <- scanner.Bytes()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unexpected error in input tcp connection:", err)
+		return
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "Unexpected error in input tcp connection:", err)
+	for {
+		buf, err := decoder.Decode()
+
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, "Unexpected error in input tcp connection:", err)
+			}
+			return
+		}
+
+		// Decoder reuses buffer, so we have to allocate new array
+		newBuf := make([]byte, len(buf))
+		copy(newBuf, buf)
+
+		i.data <- newBuf
 	}
 }
 
@@ -91,6 +91,15 @@ func (l *Limiter) Read(data []byte) (n int, err error) {
 	return
 }
 
+// Close closes wrapped plugin, if it supports closing
+func (l *Limiter) Close() error {
+	if c, ok := l.plugin.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
 func (l *Limiter) String() string {
 	return fmt.Sprintf("Limiting %s to: %d (isPercent: %b)", l.plugin, l.limit, l.isPercent)
 }
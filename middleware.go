@@ -2,89 +2,365 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	// Emit original message if middleware did not reply in time
+	middlewarePolicyPass = "pass"
+	// Drop message if middleware did not reply in time
+	middlewarePolicyDrop = "drop"
+
+	middlewareMinBackoff = 100 * time.Millisecond
+	middlewareMaxBackoff = 10 * time.Second
+)
+
+var errMiddlewareNotRunning = errors.New("Middleware is not running")
+
+// MiddlewareConfig holds options shared by all middleware commands
+type MiddlewareConfig struct {
+	// Maximum time to wait for middleware reply, disabled if 0
+	timeout time.Duration
+	// What to do with message if middleware did not reply in time, or can't receive it because it is restarting
+	timeoutPolicy string
+
+	// Number of processes started for each command, see MiddlewarePool
+	workers int
+	// Messages with the same session key value go to the same worker
+	sessionKey string
+}
+
+// Middleware runs external command and communicates with it using STDIN and STDOUT.
+// If command exits, it gets restarted with exponential backoff.
 type Middleware struct {
-	command string
+	command  string
+	args     []string
+	protocol string
+	config   *MiddlewareConfig
 
 	data chan []byte
+	stop chan struct{}
 
-	mu sync.Mutex
+	// Guards process and its STDIN, which change on restart
+	mu      sync.Mutex
+	stdin   io.WriteCloser
+	process *os.Process
+	closed  bool
 
-	Stdin  io.Writer
-	Stdout io.Reader
+	// Messages waiting for middleware reply, tracked only if timeout set
+	pendingMu sync.Mutex
+	pending   map[string]middlewarePending
+
+	// Health counters, accessed atomically
+	running  int32
+	sent     int64
+	received int64
+	timeouts int64
+	failed   int64
+	restarts int64
+}
+
+type middlewarePending struct {
+	// Original payload, stored only for `pass` policy
+	payload []byte
+	sent    time.Time
 }
 
-func NewMiddleware(command string) *Middleware {
+// NewMiddleware starts middleware command and supervises it
+func NewMiddleware(command string, config *MiddlewareConfig) *Middleware {
 	m := new(Middleware)
 	m.command = command
+
+	// Config shared between all middlewares, and defaults below are set per instance
+	c := *config
+	m.config = &c
+	m.protocol = Settings.middlewareProtocol
 	m.data = make(chan []byte, 1000)
+	m.stop = make(chan struct{})
 
-	commands := strings.Split(command, " ")
-	cmd := exec.Command(commands[0], commands[1:]...)
+	if m.protocol != "" && m.protocol != middlewareProtocolHex && m.protocol != middlewareProtocolJSON {
+		log.Fatal("Unknown middleware protocol: ", m.protocol)
+	}
 
-	m.Stdout, _ = cmd.StdoutPipe()
-	m.Stdin, _ = cmd.StdinPipe()
+	if m.config.timeoutPolicy == "" {
+		m.config.timeoutPolicy = middlewarePolicyDrop
+	}
+
+	if m.config.timeoutPolicy != middlewarePolicyPass && m.config.timeoutPolicy != middlewarePolicyDrop {
+		log.Fatal("Unknown middleware timeout policy: ", m.config.timeoutPolicy)
+	}
+
+	var err error
+	if m.args, err = splitCommand(command); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(m.args) == 0 {
+		log.Fatal("Middleware command is empty")
+	}
+
+	if m.config.timeout > 0 {
+		m.pending = make(map[string]middlewarePending)
+		go m.timeoutTicker()
+	}
+
+	cmd, stdout, err := m.start()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go m.supervise(cmd, stdout)
+
+	if Settings.stats {
+		go m.reportStats()
+	}
+
+	return m
+}
+
+// supervise waits for command to exit, and restarts it
+func (m *Middleware) supervise(cmd *exec.Cmd, stdout io.Reader) {
+	backoff := middlewareMinBackoff
+
+	for {
+		started := time.Now()
+		err := m.wait(cmd, stdout)
+
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		// Command which worked for a while considered healthy, so backoff starts over
+		if time.Since(started) > middlewareMaxBackoff {
+			backoff = middlewareMinBackoff
+		}
+
+		atomic.AddInt64(&m.restarts, 1)
+		log.Println("Middleware command", m.command, "exited:", err, "Restarting in", backoff)
+
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > middlewareMaxBackoff {
+				backoff = middlewareMaxBackoff
+			}
+
+			if cmd, stdout, err = m.start(); err == nil {
+				break
+			}
+
+			log.Println("Middleware command", m.command, "failed to start:", err, "Restarting in", backoff)
+		}
+	}
+}
+
+// start runs command, messages can be sent to it right after start
+func (m *Middleware) start() (cmd *exec.Cmd, stdout io.Reader, err error) {
+	cmd = exec.Command(m.args[0], m.args[1:]...)
+
+	if stdout, err = cmd.StdoutPipe(); err != nil {
+		return
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
 
 	if Settings.verbose {
 		cmd.Stderr = os.Stderr
 	}
 
-	go m.read(m.Stdout)
+	if err = cmd.Start(); err != nil {
+		return
+	}
 
-	go func() {
-		err := cmd.Start()
+	m.mu.Lock()
+	m.stdin = stdin
+	m.process = cmd.Process
+	if m.closed {
+		cmd.Process.Kill()
+	}
+	m.mu.Unlock()
 
-		if err != nil {
-			log.Fatal(err)
-		}
+	atomic.StoreInt32(&m.running, 1)
+
+	return
+}
 
-		cmd.Wait()
-	}()
+// wait reads command output until it exits
+func (m *Middleware) wait(cmd *exec.Cmd, stdout io.Reader) error {
+	m.read(stdout)
 
-	return m
+	atomic.StoreInt32(&m.running, 0)
+
+	m.mu.Lock()
+	m.stdin.Close()
+	m.stdin = nil
+	m.process = nil
+	m.mu.Unlock()
+
+	// Exited process will not reply to messages it already received
+	m.abandon()
+
+	return cmd.Wait()
 }
 
-func (m *Middleware) ReadFrom(plugin io.Reader) {
+// ReadFromPlugin starts sending payloads read from plugin to middleware
+func (m *Middleware) ReadFromPlugin(plugin io.Reader) {
 	Debug("[MIDDLEWARE-MASTER] Starting reading from", plugin)
-	go m.copy(m.Stdin, plugin)
+	go m.copy(plugin)
 }
 
-func (m *Middleware) copy(to io.Writer, from io.Reader) {
+func (m *Middleware) copy(from io.Reader) {
 	buf := make([]byte, 5*1024*1024)
-	dst := make([]byte, len(buf)*2)
 
 	for {
-		nr, _ := from.Read(buf)
+		nr, err := from.Read(buf)
 		if nr > 0 && len(buf) > nr {
+			m.Write(buf[0:nr])
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Middleware failed to read from", from, err)
+			}
+			return
+		}
+	}
+}
+
+// Write sends payload to middleware
+func (m *Middleware) Write(payload []byte) (int, error) {
+	if m.protocol == middlewareProtocolJSON {
+		m.writeJSON(payload)
+		return len(payload), nil
+	}
+
+	dst := make([]byte, len(payload)*2+1)
+	hex.Encode(dst, payload)
+	dst[len(dst)-1] = '\n'
 
-			hex.Encode(dst, buf[0:nr])
-			dst[nr*2] = '\n'
+	m.send(dst, payload)
 
-			m.mu.Lock()
-			to.Write(dst[0 : nr*2+1])
-			m.mu.Unlock()
+	if Settings.debug {
+		Debug("[MIDDLEWARE-MASTER] Sending:", string(payload))
+	}
+
+	return len(payload), nil
+}
+
+// send writes encoded line to middleware STDIN
+func (m *Middleware) send(line []byte, payload []byte) {
+	m.track(payload)
+
+	err := errMiddlewareNotRunning
+
+	m.mu.Lock()
+	if m.stdin != nil {
+		_, err = m.stdin.Write(line)
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		atomic.AddInt64(&m.failed, 1)
+		Debug("[MIDDLEWARE-MASTER] Failed to send message:", err)
+
+		if p, ok := m.resolve(payload); ok {
+			m.fallback(p.payload)
+		}
+		return
+	}
+
+	atomic.AddInt64(&m.sent, 1)
+}
+
+// writeJSON sends payload as single JSON line
+func (m *Middleware) writeJSON(payload []byte) {
+	line, err := json.Marshal(newMiddlewareMessage(payload))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to encode middleware message", err)
+		return
+	}
+
+	m.send(append(line, '\n'), payload)
+
+	if Settings.debug {
+		Debug("[MIDDLEWARE-MASTER] Sending:", string(line))
+	}
+}
+
+// readJSON decodes middleware reply, returns nil if message should be dropped.
+// Tells if message is injected by middleware, and so does not reply to any sent message.
+func (m *Middleware) readJSON(line []byte) (payload []byte, inject bool) {
+	msg := new(MiddlewareMessage)
+
+	if err := json.Unmarshal(line, msg); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to decode middleware message", err, len(line))
+		return nil, false
+	}
 
-			if Settings.debug {
-				Debug("[MIDDLEWARE-MASTER] Sending:", string(buf[0:nr]), "From:", from)
+	if msg.Action == middlewareActionDrop {
+		for t, name := range payloadTypeNames {
+			if name == msg.Type {
+				m.resolveKey(middlewarePendingKey(t, []byte(msg.UUID)))
 			}
 		}
+		return nil, false
+	}
+
+	if msg.Action != "" && msg.Action != middlewareActionEmit && msg.Action != middlewareActionInject {
+		fmt.Fprintln(os.Stderr, "Unknown middleware message action:", msg.Action)
+		return nil, false
 	}
+
+	payload, err := msg.Payload()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to decode middleware message", err)
+		return nil, false
+	}
+
+	return payload, msg.Action == middlewareActionInject
 }
 
 func (m *Middleware) read(from io.Reader) {
 	scanner := bufio.NewScanner(from)
+	// Hex and JSON encoding make messages larger than payloads
+	scanner.Buffer(make([]byte, 64*1024), 20*1024*1024)
 
 	for scanner.Scan() {
 		bytes := scanner.Bytes()
+
+		if m.protocol == middlewareProtocolJSON {
+			if buf, inject := m.readJSON(bytes); buf != nil {
+				if Settings.debug {
+					Debug("[MIDDLEWARE-MASTER] Received:", string(buf))
+				}
+
+				atomic.AddInt64(&m.received, 1)
+				m.reply(buf, inject)
+			}
+			continue
+		}
+
 		buf := make([]byte, len(bytes)/2)
 		if _, err := hex.Decode(buf, bytes); err != nil {
 			fmt.Fprintln(os.Stderr, "Failed to decode input payload", err, len(bytes))
@@ -94,7 +370,8 @@ func (m *Middleware) read(from io.Reader) {
 			Debug("[MIDDLEWARE-MASTER] Received:", string(buf))
 		}
 
-		m.data <- buf
+		atomic.AddInt64(&m.received, 1)
+		m.reply(buf, false)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -104,13 +381,255 @@ func (m *Middleware) read(from io.Reader) {
 	return
 }
 
+// reply emits middleware reply. If messages are tracked, reply emitted only if it matches message which still waits
+// for reply: timeout policy was already applied to late replies.
+func (m *Middleware) reply(payload []byte, inject bool) {
+	if _, ok := m.resolve(payload); ok || inject || m.pending == nil {
+		m.emit(payload)
+		return
+	}
+
+	Debug("[MIDDLEWARE-MASTER] Skipping reply without pending message:", payloadPendingKey(payload))
+}
+
+func (m *Middleware) emit(payload []byte) {
+	select {
+	case m.data <- payload:
+	case <-m.stop:
+	}
+}
+
+// middlewarePendingKey identifies message by type and id, so reply can be matched with original message
+func middlewarePendingKey(payloadType byte, uuid []byte) string {
+	return string(payloadType) + string(uuid)
+}
+
+func payloadPendingKey(payload []byte) string {
+	meta := payloadMeta(payload)
+
+	if len(meta) < 2 || len(meta[0]) == 0 {
+		return ""
+	}
+
+	return middlewarePendingKey(meta[0][0], meta[1])
+}
+
+// track remembers message, to apply timeout policy if middleware will not reply in time
+func (m *Middleware) track(payload []byte) {
+	if m.pending == nil {
+		return
+	}
+
+	p := middlewarePending{sent: time.Now()}
+
+	if m.config.timeoutPolicy == middlewarePolicyPass {
+		p.payload = append([]byte(nil), payload...)
+	}
+
+	m.pendingMu.Lock()
+	m.pending[payloadPendingKey(payload)] = p
+	m.pendingMu.Unlock()
+}
+
+// resolve marks message as processed by middleware
+func (m *Middleware) resolve(payload []byte) (middlewarePending, bool) {
+	if m.pending == nil {
+		return middlewarePending{}, false
+	}
+
+	return m.resolveKey(payloadPendingKey(payload))
+}
+
+func (m *Middleware) resolveKey(key string) (middlewarePending, bool) {
+	if m.pending == nil {
+		return middlewarePending{}, false
+	}
+
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+
+	p, ok := m.pending[key]
+	delete(m.pending, key)
+
+	return p, ok
+}
+
+// fallback emits original payload if `pass` policy used
+func (m *Middleware) fallback(payload []byte) {
+	if m.config.timeoutPolicy == middlewarePolicyPass && payload != nil {
+		m.emit(payload)
+	}
+}
+
+// abandon applies timeout policy to all messages waiting for reply, used when process exits
+func (m *Middleware) abandon() {
+	if m.pending == nil {
+		return
+	}
+
+	m.pendingMu.Lock()
+	abandoned := make([]middlewarePending, 0, len(m.pending))
+	for key, p := range m.pending {
+		abandoned = append(abandoned, p)
+		delete(m.pending, key)
+	}
+	m.pendingMu.Unlock()
+
+	for _, p := range abandoned {
+		atomic.AddInt64(&m.failed, 1)
+		m.fallback(p.payload)
+	}
+}
+
+// timeoutTicker applies timeout policy to messages which middleware did not reply in time
+func (m *Middleware) timeoutTicker() {
+	interval := m.config.timeout / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		var expired []middlewarePending
+		now := time.Now()
+
+		m.pendingMu.Lock()
+		for key, p := range m.pending {
+			if now.Sub(p.sent) >= m.config.timeout {
+				expired = append(expired, p)
+				delete(m.pending, key)
+			}
+		}
+		m.pendingMu.Unlock()
+
+		for _, p := range expired {
+			atomic.AddInt64(&m.timeouts, 1)
+			m.fallback(p.payload)
+		}
+	}
+}
+
+// Health returns middleware stats: running,sent,received,timeouts,failed,restarts
+func (m *Middleware) Health() string {
+	return strconv.Itoa(int(atomic.LoadInt32(&m.running))) + "," +
+		strconv.FormatInt(atomic.LoadInt64(&m.sent), 10) + "," +
+		strconv.FormatInt(atomic.LoadInt64(&m.received), 10) + "," +
+		strconv.FormatInt(atomic.LoadInt64(&m.timeouts), 10) + "," +
+		strconv.FormatInt(atomic.LoadInt64(&m.failed), 10) + "," +
+		strconv.FormatInt(atomic.LoadInt64(&m.restarts), 10)
+}
+
+func (m *Middleware) reportStats() {
+	statName := "middleware[" + m.command + "]"
+	log.Println(statName + ":running,sent,received,timeouts,failed,restarts")
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(rate * time.Second):
+		}
+
+		log.Println(statName + ":" + m.Health())
+	}
+}
+
 func (m *Middleware) Read(data []byte) (int, error) {
-	buf := <-m.data
-	copy(data, buf)
+	select {
+	case buf := <-m.data:
+		copy(data, buf)
+		return len(buf), nil
+	case <-m.stop:
+		return 0, io.EOF
+	}
+}
+
+// Close stops middleware command
+func (m *Middleware) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+
+	m.closed = true
+	close(m.stop)
 
-	return len(buf), nil
+	if m.process != nil {
+		m.process.Kill()
+	}
+
+	return nil
 }
 
 func (m *Middleware) String() string {
 	return fmt.Sprintf("Modifying traffic using '%s' command", m.command)
 }
+
+// splitCommand splits command line into arguments the way shell does:
+// arguments separated by spaces, quotes group words, backslash escapes next character
+func splitCommand(command string) (args []string, err error) {
+	var arg bytes.Buffer
+	var quote byte
+	inArg := false
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg.WriteByte(c)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else if c == '\\' && i+1 < len(command) && bytes.IndexByte([]byte("\"\\$`"), command[i+1]) != -1 {
+				i++
+				arg.WriteByte(command[i])
+			} else {
+				arg.WriteByte(c)
+			}
+		case c == '\\':
+			if i+1 == len(command) {
+				return nil, fmt.Errorf("Unexpected end of command after backslash: %s", command)
+			}
+			i++
+			arg.WriteByte(command[i])
+			inArg = true
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote in command: %s", command)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return
+}
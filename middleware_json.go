@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/buger/gor/proto"
+)
+
+const (
+	middlewareProtocolHex  = "hex"
+	middlewareProtocolJSON = "json"
+)
+
+// Actions which middleware can specify in reply message
+const (
+	// Emit message (default), possibly modified
+	middlewareActionEmit = "emit"
+	// Do not emit anything
+	middlewareActionDrop = "drop"
+	// Emit new request which was not captured by input
+	middlewareActionInject = "inject"
+)
+
+var payloadTypeNames = map[byte]string{
+	RequestPayload:          "request",
+	ResponsePayload:         "response",
+	ReplayedResponsePayload: "replayed_response",
+}
+
+// MiddlewareMessage is a payload representation used by JSON middleware protocol
+//
+// Each message is sent as single JSON line:
+//
+//	{"type":"request","uuid":"932079936fa4306fc308d67588178d17d823647c","timing":1439818823587396305,"meta":{"src":"10.0.0.1:51234"},
+//	 "http":{"method":"GET","path":"/a","proto":"HTTP/1.1","headers":[["Host","127.0.0.1"]],"body":""}}
+//
+// Reply can contain the same message (modified or not), or message with "action" field:
+// "drop" to skip message, or "inject" to emit new request.
+type MiddlewareMessage struct {
+	Action string            `json:"action,omitempty"`
+	Type   string            `json:"type"`
+	UUID   string            `json:"uuid"`
+	Timing int64             `json:"timing"`
+	Meta   map[string]string `json:"meta,omitempty"`
+
+	// Parsed HTTP payload
+	HTTP *MiddlewareHTTP `json:"http,omitempty"`
+
+	// Base64 encoded payload, used if payload can't be parsed as HTTP
+	Raw string `json:"raw,omitempty"`
+
+	// Request and original response for response messages, if correlation store enabled. Ignored in reply.
+	Correlation *MiddlewareCorrelation `json:"correlation,omitempty"`
+}
+
+// MiddlewareCorrelation holds messages with the same id, received before current one
+type MiddlewareCorrelation struct {
+	Request  *MiddlewareHTTP `json:"request,omitempty"`
+	Response *MiddlewareHTTP `json:"response,omitempty"`
+}
+
+// MiddlewareHTTP holds parsed HTTP request or response
+// Request have Method and Path fields, response have Status and Reason.
+type MiddlewareHTTP struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Proto  string `json:"proto"`
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// List of name/value pairs, keeping original order and duplicates
+	Headers [][2]string `json:"headers"`
+
+	Body string `json:"body"`
+	// If body is not valid UTF-8 it is base64 encoded, and this field set to "base64"
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+var errMiddlewareMessageType = errors.New("Unknown middleware message type")
+
+// newMiddlewareMessage converts payload to message
+func newMiddlewareMessage(payload []byte) *MiddlewareMessage {
+	meta := payloadMeta(payload)
+	body := payloadBody(payload)
+
+	msg := &MiddlewareMessage{
+		Type: payloadTypeNames[meta[0][0]],
+	}
+
+	if len(meta) > 1 {
+		msg.UUID = string(meta[1])
+	}
+
+	if len(meta) > 2 {
+		msg.Timing, _ = strconv.ParseInt(string(meta[2]), 10, 64)
+	}
+
+	msg.Meta = payloadMetaFields(meta)
+
+	if msg.HTTP = parseMiddlewareHTTP(body); msg.HTTP == nil {
+		msg.Raw = base64.StdEncoding.EncodeToString(body)
+	}
+
+	if store := Correlations; store != nil && meta[0][0] != RequestPayload && len(meta) > 1 {
+		if c, ok := store.Get(meta[1]); ok {
+			msg.Correlation = &MiddlewareCorrelation{Request: parseMiddlewareHTTP(c.Request)}
+
+			if meta[0][0] == ReplayedResponsePayload {
+				msg.Correlation.Response = parseMiddlewareHTTP(c.Response)
+			}
+		}
+	}
+
+	return msg
+}
+
+// parseMiddlewareHTTP returns nil if payload is not HTTP
+func parseMiddlewareHTTP(payload []byte) *MiddlewareHTTP {
+	headEnd := bytes.Index(payload, []byte("\r\n\r\n"))
+	if headEnd == -1 {
+		return nil
+	}
+
+	lines := strings.Split(string(payload[:headEnd]), "\r\n")
+	h := new(MiddlewareHTTP)
+
+	if bytes.HasPrefix(payload, []byte("HTTP/")) {
+		s, _, err := proto.ParseStatusLine(payload)
+		if err != nil {
+			return nil
+		}
+
+		h.Proto, h.Status, h.Reason = string(s.Version), s.Status, string(s.Reason)
+	} else {
+		r, _, err := proto.ParseRequestLine(payload)
+		if err != nil {
+			return nil
+		}
+
+		h.Method, h.Path, h.Proto = string(r.Method), string(r.Target), string(r.Version)
+	}
+
+	h.Headers = make([][2]string, 0, len(lines)-1)
+
+	for _, line := range lines[1:] {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil
+		}
+
+		h.Headers = append(h.Headers, [2]string{kv[0], strings.TrimSpace(kv[1])})
+	}
+
+	body := payload[headEnd+4:]
+
+	if utf8.Valid(body) {
+		h.Body = string(body)
+	} else {
+		h.Body = base64.StdEncoding.EncodeToString(body)
+		h.BodyEncoding = "base64"
+	}
+
+	return h
+}
+
+// Payload converts message back to payload
+func (msg *MiddlewareMessage) Payload() ([]byte, error) {
+	var payloadType byte
+
+	for t, name := range payloadTypeNames {
+		if name == msg.Type {
+			payloadType = t
+		}
+	}
+
+	// Injected messages can omit all fields except http
+	if msg.Action == middlewareActionInject {
+		payloadType = RequestPayload
+
+		if msg.UUID == "" {
+			msg.UUID = string(uuid())
+		}
+
+		if msg.Timing == 0 {
+			msg.Timing = time.Now().UnixNano()
+		}
+	}
+
+	if payloadType == 0 {
+		return nil, errMiddlewareMessageType
+	}
+
+	payload := payloadHeader(payloadType, []byte(msg.UUID), msg.Timing, metaFields(msg.Meta)...)
+
+	if msg.HTTP == nil {
+		raw, err := base64.StdEncoding.DecodeString(msg.Raw)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(payload, raw...), nil
+	}
+
+	body, err := msg.HTTP.body()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(payload, msg.HTTP.Bytes(body)...), nil
+}
+
+func (h *MiddlewareHTTP) body() ([]byte, error) {
+	if h.BodyEncoding == "base64" {
+		return base64.StdEncoding.DecodeString(h.Body)
+	}
+
+	return []byte(h.Body), nil
+}
+
+// Bytes serializes HTTP message. Content-Length header updated if body length changed.
+func (h *MiddlewareHTTP) Bytes(body []byte) []byte {
+	buf := new(bytes.Buffer)
+
+	if h.Method != "" {
+		buf.WriteString(h.Method + " " + h.Path + " " + h.Proto)
+	} else {
+		buf.WriteString(h.Proto + " " + strconv.Itoa(h.Status))
+
+		if h.Reason != "" {
+			buf.WriteString(" " + h.Reason)
+		}
+	}
+
+	buf.WriteString("\r\n")
+
+	for _, header := range h.Headers {
+		value := header[1]
+
+		if strings.EqualFold(header[0], "Content-Length") {
+			value = strconv.Itoa(len(body))
+		}
+
+		buf.WriteString(header[0] + ": " + value + "\r\n")
+	}
+
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	return buf.Bytes()
+}
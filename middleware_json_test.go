@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestMiddlewareMessageRoundTrip(t *testing.T) {
+	payloads := [][]byte{
+		[]byte("1 8e091765ae902fef8a2b7d9dd960e9d52222bd8c 1439818823587396305 input=raw src=10.0.0.1:5123\nPOST /a?b=1 HTTP/1.1\r\nHost: example.com\r\nContent-Length: 7\r\nX-A: 1\r\nX-A: 2\r\n\r\na=1&b=2"),
+		[]byte("2 8e091765ae902fef8a2b7d9dd960e9d52222bd8c 2782013\nHTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\n\xff\x00\xfe"),
+		[]byte("3 8e091765ae902fef8a2b7d9dd960e9d52222bd8c 2782013\nnot http payload"),
+	}
+
+	for _, p := range payloads {
+		line, err := json.Marshal(newMiddlewareMessage(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := new(MiddlewareMessage)
+		if err := json.Unmarshal(line, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		payload, err := msg.Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(payload, p) {
+			t.Errorf("Payload should not change after round trip:\n%q\n%q", p, payload)
+		}
+	}
+}
+
+func TestMiddlewareMessageFields(t *testing.T) {
+	msg := newMiddlewareMessage([]byte("1 abc 1 conn=ff src=10.0.0.1:5123\nGET /a HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+
+	if msg.Type != "request" || msg.UUID != "abc" || msg.Timing != 1 {
+		t.Error("Wrong header fields:", msg)
+	}
+
+	if msg.Meta["src"] != "10.0.0.1:5123" || msg.Meta["conn"] != "ff" {
+		t.Error("Wrong meta:", msg.Meta)
+	}
+
+	if msg.HTTP.Method != "GET" || msg.HTTP.Path != "/a" || msg.HTTP.Headers[0] != [2]string{"Host", "example.com"} {
+		t.Error("Wrong http fields:", msg.HTTP)
+	}
+
+	// Meta fields serialized in sorted order
+	payload, _ := msg.Payload()
+	if !bytes.HasPrefix(payload, []byte("1 abc 1 conn=ff src=10.0.0.1:5123\n")) {
+		t.Errorf("Wrong header: %q", payload)
+	}
+}
+
+func TestMiddlewareMessageContentLength(t *testing.T) {
+	msg := newMiddlewareMessage([]byte("1 abc 1\nPOST / HTTP/1.1\r\ncontent-length: 7\r\n\r\na=1&b=2"))
+	msg.HTTP.Body = "a=1"
+
+	payload, _ := msg.Payload()
+
+	if !bytes.Equal(payloadBody(payload), []byte("POST / HTTP/1.1\r\ncontent-length: 3\r\n\r\na=1")) {
+		t.Errorf("Content-Length should be updated: %q", payload)
+	}
+}
+
+func TestMiddlewareReadJSON(t *testing.T) {
+	m := &Middleware{protocol: middlewareProtocolJSON}
+
+	if payload, _ := m.readJSON([]byte(`{"action":"drop","type":"request","uuid":"abc","timing":1}`)); payload != nil {
+		t.Error("Dropped message should not be emitted")
+	}
+
+	payload, inject := m.readJSON([]byte(`{"action":"inject","http":{"method":"GET","path":"/new","proto":"HTTP/1.1","headers":[["Host","example.com"]]}}`))
+	meta := payloadMeta(payload)
+
+	if !inject {
+		t.Error("Message should be marked as injected")
+	}
+
+	if !isRequestPayload(payload) || len(meta[1]) == 0 || len(meta[2]) == 0 {
+		t.Errorf("Injected request should get uuid and timing: %q", payload)
+	}
+
+	if !bytes.Equal(payloadBody(payload), []byte("GET /new HTTP/1.1\r\nHost: example.com\r\n\r\n")) {
+		t.Errorf("Wrong injected request: %q", payload)
+	}
+
+	if payload, _ := m.readJSON([]byte(`{"type":"unknown"}`)); payload != nil {
+		t.Error("Message with unknown type should be skipped")
+	}
+}
+
+func TestJSONMiddleware(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	input := NewTestInput()
+	output := NewTestOutput(func(data []byte) {
+		if !bytes.Equal(payloadBody(data), []byte("POST /pub/WWW/ HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\n\r\na=1&b=2")) {
+			t.Errorf("Payload should be echoed: %q", data)
+		}
+		wg.Done()
+	})
+
+	Settings.middleware = MultiOption{"cat"}
+	Settings.middlewareProtocol = middlewareProtocolJSON
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = []io.Writer{output}
+
+	go Start(quit)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		input.EmitPOST()
+	}
+
+	wg.Wait()
+	close(quit)
+
+	Settings.middleware = nil
+	Settings.middlewareProtocol = middlewareProtocolHex
+}
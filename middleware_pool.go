@@ -0,0 +1,252 @@
+package main
+
+import (
+	"hash/fnv"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/gor/proto"
+)
+
+// How long to remember which worker handled request, so its responses go to the same worker
+const middlewareRouteTTL = time.Minute
+
+// middlewareStage is implemented by both single middleware and worker pool
+type middlewareStage interface {
+	io.Reader
+	ReadFromPlugin(plugin io.Reader)
+	Close() error
+}
+
+// newMiddlewareStage starts middleware command, or pool of commands if multiple workers configured
+func newMiddlewareStage(command string, config *MiddlewareConfig) middlewareStage {
+	if config.workers > 1 {
+		return NewMiddlewarePool(command, config)
+	}
+
+	return NewMiddleware(command, config)
+}
+
+// MiddlewarePool runs multiple processes of the same middleware command and distributes messages between them.
+//
+// Request, original and replayed responses with the same id always go to the same worker.
+// If session key configured, all requests with the same key value (and their responses) go to the same worker as well.
+// Session key can be `header:<name>`, `param:<name>` or `meta:<key>`, e.g. `header:X-Session-Id`.
+type MiddlewarePool struct {
+	command string
+	config  *MiddlewareConfig
+	workers []*Middleware
+
+	sessionSource string
+	sessionName   []byte
+
+	data      chan []byte
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	// request id -> worker index, used only if session key configured
+	mu     sync.Mutex
+	routes map[string]middlewareRoute
+}
+
+type middlewareRoute struct {
+	worker int
+	seen   time.Time
+}
+
+// NewMiddlewarePool starts `config.workers` processes of given command
+func NewMiddlewarePool(command string, config *MiddlewareConfig) *MiddlewarePool {
+	p := new(MiddlewarePool)
+	p.command = command
+	p.config = config
+	p.data = make(chan []byte, 1000)
+	p.stop = make(chan struct{})
+
+	if config.sessionKey != "" {
+		kv := strings.SplitN(config.sessionKey, ":", 2)
+
+		if len(kv) != 2 || (kv[0] != "header" && kv[0] != "param" && kv[0] != "meta") {
+			log.Fatal("Middleware session key should be `header:<name>`, `param:<name>` or `meta:<key>`, got: ", config.sessionKey)
+		}
+
+		p.sessionSource, p.sessionName = kv[0], []byte(kv[1])
+		p.routes = make(map[string]middlewareRoute)
+
+		go p.expireRoutes()
+	}
+
+	for i := 0; i < config.workers; i++ {
+		w := NewMiddleware(command, config)
+		p.workers = append(p.workers, w)
+
+		go p.merge(w)
+	}
+
+	return p
+}
+
+// merge forwards worker output to the pool output
+func (p *MiddlewarePool) merge(w *Middleware) {
+	buf := make([]byte, 5*1024*1024)
+
+	for {
+		n, err := w.Read(buf)
+		if err != nil {
+			return
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		select {
+		case p.data <- data:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// ReadFromPlugin starts routing payloads read from plugin to workers
+func (p *MiddlewarePool) ReadFromPlugin(plugin io.Reader) {
+	Debug("[MIDDLEWARE-POOL] Starting reading from", plugin)
+	go p.copy(plugin)
+}
+
+func (p *MiddlewarePool) copy(from io.Reader) {
+	buf := make([]byte, 5*1024*1024)
+
+	for {
+		nr, err := from.Read(buf)
+		if nr > 0 && len(buf) > nr {
+			p.workers[p.worker(buf[0:nr])].Write(buf[0:nr])
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Middleware failed to read from", from, err)
+			}
+			return
+		}
+	}
+}
+
+// worker chooses worker for the payload
+func (p *MiddlewarePool) worker(payload []byte) int {
+	meta := payloadMeta(payload)
+
+	var id []byte
+	if len(meta) > 1 {
+		id = meta[1]
+	}
+
+	if p.routes == nil {
+		return workerIndex(id, len(p.workers))
+	}
+
+	if isRequestPayload(payload) {
+		key := p.sessionValue(meta, payloadBody(payload))
+		if len(key) == 0 {
+			key = id
+		}
+
+		w := workerIndex(key, len(p.workers))
+
+		p.mu.Lock()
+		p.routes[string(id)] = middlewareRoute{w, time.Now()}
+		p.mu.Unlock()
+
+		return w
+	}
+
+	p.mu.Lock()
+	route, ok := p.routes[string(id)]
+	p.mu.Unlock()
+
+	if ok {
+		return route.worker
+	}
+
+	return workerIndex(id, len(p.workers))
+}
+
+// sessionValue extracts session key value from request
+func (p *MiddlewarePool) sessionValue(meta [][]byte, body []byte) []byte {
+	switch p.sessionSource {
+	case "meta":
+		return payloadMetaValue(meta, string(p.sessionName))
+	case "header":
+		if proto.MIMEHeadersEndPos(body) == -1 {
+			return nil
+		}
+		return proto.Header(body, p.sessionName)
+	case "param":
+		if proto.MIMEHeadersEndPos(body) == -1 {
+			return nil
+		}
+		value, _, _ := proto.PathParam(body, p.sessionName)
+		return value
+	}
+
+	return nil
+}
+
+func workerIndex(key []byte, workers int) int {
+	hasher := fnv.New32a()
+	hasher.Write(key)
+
+	return int(hasher.Sum32() % uint32(workers))
+}
+
+// expireRoutes removes routes of requests which got all their responses long ago
+func (p *MiddlewarePool) expireRoutes() {
+	ticker := time.NewTicker(middlewareRouteTTL / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		p.mu.Lock()
+		for id, route := range p.routes {
+			if now.Sub(route.seen) > middlewareRouteTTL {
+				delete(p.routes, id)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *MiddlewarePool) Read(data []byte) (int, error) {
+	select {
+	case buf := <-p.data:
+		copy(data, buf)
+		return len(buf), nil
+	case <-p.stop:
+		return 0, io.EOF
+	}
+}
+
+// Close stops all workers
+func (p *MiddlewarePool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+
+		for _, w := range p.workers {
+			w.Close()
+		}
+	})
+
+	return nil
+}
+
+func (p *MiddlewarePool) String() string {
+	return "Modifying traffic using pool of '" + p.command + "' commands"
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMiddlewarePoolRouting(t *testing.T) {
+	p := NewMiddlewarePool("cat", &MiddlewareConfig{workers: 8, sessionKey: "header:X-Session"})
+	defer p.Close()
+
+	sessionWorker := -1
+
+	for i := 0; i < 20; i++ {
+		id := strconv.Itoa(i)
+
+		w := p.worker([]byte("1 " + id + " 1\nGET / HTTP/1.1\r\nX-Session: abc\r\n\r\n"))
+
+		if sessionWorker == -1 {
+			sessionWorker = w
+		}
+
+		if w != sessionWorker {
+			t.Error("Requests with the same session should go to the same worker", w, sessionWorker)
+		}
+
+		if p.worker([]byte("2 "+id+" 1\nHTTP/1.1 200 OK\r\n\r\n")) != w || p.worker([]byte("3 "+id+" 1\nHTTP/1.1 200 OK\r\n\r\n")) != w {
+			t.Error("Responses should go to the same worker as request")
+		}
+	}
+
+	// Without session key, worker chosen by request id
+	workers := make(map[int]bool)
+	for i := 0; i < 20; i++ {
+		workers[p.worker([]byte("1 "+strconv.Itoa(i)+" 1\nGET / HTTP/1.1\r\n\r\n"))] = true
+	}
+
+	if len(workers) < 2 {
+		t.Error("Requests without session should be distributed between workers")
+	}
+}
+
+func TestMiddlewarePool(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	input := NewTestInput()
+	output := NewTestOutput(func(data []byte) {
+		wg.Done()
+	})
+
+	Settings.middleware = MultiOption{"cat"}
+	Settings.middlewareConfig.workers = 4
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = []io.Writer{output}
+
+	go Start(quit)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		input.EmitGET()
+	}
+
+	wg.Wait()
+	close(quit)
+
+	Settings.middleware = nil
+	Settings.middlewareConfig.workers = 1
+}
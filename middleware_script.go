@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/buger/gor/processor"
+	"github.com/buger/gor/proto"
+	"github.com/yuin/gopher-lua"
+)
+
+// Script hooks called for each payload type
+var scriptHooks = map[byte]string{
+	RequestPayload:          "on_request",
+	ResponsePayload:         "on_response",
+	ReplayedResponsePayload: "on_replayed_response",
+}
+
+const scriptMessageType = "gor_message"
+
+// ScriptProcessor runs Lua script as in-process middleware
+//
+// Script can define `on_request`, `on_response` and `on_replayed_response` functions.
+// Each function receives message and should return it back (possibly modified), nothing to drop message,
+// or multiple messages to inject new requests. If function not defined, messages pass untouched.
+//
+//	function on_request(req)
+//	    req:set_header("X-Replayed", "1")
+//	    return req
+//	end
+//
+// Script global variables persist between calls, so script can keep state, like token aliases.
+// Lua interpreter is not thread-safe: calls are serialized.
+type ScriptProcessor struct {
+	mu   sync.Mutex
+	path string
+	L    *lua.LState
+}
+
+// NewScriptProcessor loads Lua script from given file
+func NewScriptProcessor(path string) *ScriptProcessor {
+	p := new(ScriptProcessor)
+	p.path = path
+	p.L = lua.NewState()
+
+	p.registerAPI()
+
+	if err := p.L.DoFile(path); err != nil {
+		log.Fatal("Cannot load middleware script: ", err)
+	}
+
+	return p
+}
+
+// Process calls script hook for message type
+func (p *ScriptProcessor) Process(msg *processor.Message) []*processor.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fn := p.L.GetGlobal(scriptHooks[msg.Type])
+	if fn.Type() != lua.LTFunction {
+		return []*processor.Message{msg}
+	}
+
+	top := p.L.GetTop()
+
+	err := p.L.CallByParam(lua.P{Fn: fn, NRet: lua.MultRet, Protect: true}, p.newMessage(msg))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Middleware script error:", err)
+		p.L.SetTop(top)
+		// Do not lose traffic because of script errors
+		return []*processor.Message{msg}
+	}
+
+	var out []*processor.Message
+
+	for i := top + 1; i <= p.L.GetTop(); i++ {
+		if ud, ok := p.L.Get(i).(*lua.LUserData); ok {
+			if m, ok := ud.Value.(*processor.Message); ok {
+				out = append(out, m)
+			}
+		}
+	}
+	p.L.SetTop(top)
+
+	return out
+}
+
+func (p *ScriptProcessor) String() string {
+	return "Middleware script: " + p.path
+}
+
+func (p *ScriptProcessor) newMessage(msg *processor.Message) *lua.LUserData {
+	ud := p.L.NewUserData()
+	ud.Value = msg
+	p.L.SetMetatable(ud, p.L.GetTypeMetatable(scriptMessageType))
+
+	return ud
+}
+
+// registerAPI defines message methods and global `gor` module
+func (p *ScriptProcessor) registerAPI() {
+	L := p.L
+
+	mt := L.NewTypeMetatable(scriptMessageType)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), scriptMessageMethods))
+
+	gor := L.NewTable()
+	L.SetFuncs(gor, map[string]lua.LGFunction{
+		// gor.request(data) creates new request, which can be returned from hook to inject it
+		"request": func(L *lua.LState) int {
+			msg := &processor.Message{Type: RequestPayload, Data: []byte(L.CheckString(1))}
+			L.Push(p.newMessage(msg))
+			return 1
+		},
+		// gor.log(...) writes debug output
+		"log": func(L *lua.LState) int {
+			args := make([]interface{}, 0, L.GetTop()+1)
+			args = append(args, "[MIDDLEWARE-SCRIPT]")
+			for i := 1; i <= L.GetTop(); i++ {
+				args = append(args, L.Get(i).String())
+			}
+			Debug(args...)
+			return 0
+		},
+	})
+	L.SetGlobal("gor", gor)
+}
+
+func checkScriptMessage(L *lua.LState) *processor.Message {
+	if msg, ok := L.CheckUserData(1).Value.(*processor.Message); ok {
+		return msg
+	}
+
+	L.ArgError(1, "message expected")
+	return nil
+}
+
+// scriptString defines method returning string value of message
+func scriptString(get func(msg *processor.Message) []byte) lua.LGFunction {
+	return func(L *lua.LState) int {
+		L.Push(lua.LString(get(checkScriptMessage(L))))
+		return 1
+	}
+}
+
+// scriptSetter defines method which modifies message using given argument
+func scriptSetter(set func(msg *processor.Message, value []byte)) lua.LGFunction {
+	return func(L *lua.LState) int {
+		set(checkScriptMessage(L), []byte(L.CheckString(2)))
+		return 0
+	}
+}
+
+// scriptNamedSetter defines method which modifies message using name and value arguments
+func scriptNamedSetter(set func(msg *processor.Message, name, value []byte)) lua.LGFunction {
+	return func(L *lua.LState) int {
+		set(checkScriptMessage(L), []byte(L.CheckString(2)), []byte(L.CheckString(3)))
+		return 0
+	}
+}
+
+var scriptMessageMethods = map[string]lua.LGFunction{
+	"type": func(L *lua.LState) int {
+		L.Push(lua.LString(payloadTypeNames[checkScriptMessage(L).Type]))
+		return 1
+	},
+	"id": scriptString(func(msg *processor.Message) []byte {
+		return msg.ID
+	}),
+	"timing": func(L *lua.LState) int {
+		L.Push(lua.LNumber(checkScriptMessage(L).Timing))
+		return 1
+	},
+	"meta": func(L *lua.LState) int {
+		L.Push(lua.LString(checkScriptMessage(L).Meta[L.CheckString(2)]))
+		return 1
+	},
+	"set_meta": func(L *lua.LState) int {
+		msg := checkScriptMessage(L)
+		if msg.Meta == nil {
+			msg.Meta = make(map[string]string)
+		}
+		msg.Meta[L.CheckString(2)] = L.CheckString(3)
+		return 0
+	},
+	"data": scriptString(func(msg *processor.Message) []byte {
+		return msg.Data
+	}),
+	"set_data": scriptSetter(func(msg *processor.Message, value []byte) {
+		msg.Data = value
+	}),
+	"method": scriptString(func(msg *processor.Message) []byte {
+		return proto.Method(msg.Data)
+	}),
+	"path": scriptString(func(msg *processor.Message) []byte {
+		return proto.Path(msg.Data)
+	}),
+	"set_path": scriptSetter(func(msg *processor.Message, value []byte) {
+		msg.Data = proto.SetPath(msg.Data, value)
+	}),
+	"status": func(L *lua.LState) int {
+		status, _ := strconv.Atoi(string(proto.Status(checkScriptMessage(L).Data)))
+		L.Push(lua.LNumber(status))
+		return 1
+	},
+	"header": func(L *lua.LState) int {
+		L.Push(lua.LString(proto.Header(checkScriptMessage(L).Data, []byte(L.CheckString(2)))))
+		return 1
+	},
+	"set_header": scriptNamedSetter(func(msg *processor.Message, name, value []byte) {
+		msg.Data = proto.SetHeader(msg.Data, name, value)
+	}),
+	"param": func(L *lua.LState) int {
+		value, _, _ := proto.PathParam(checkScriptMessage(L).Data, []byte(L.CheckString(2)))
+		L.Push(lua.LString(value))
+		return 1
+	},
+	"set_param": scriptNamedSetter(func(msg *processor.Message, name, value []byte) {
+		msg.Data = proto.SetPathParam(msg.Data, name, value)
+	}),
+	"body": scriptString(func(msg *processor.Message) []byte {
+		return proto.Body(msg.Data)
+	}),
+	"set_body": scriptSetter(func(msg *processor.Message, value []byte) {
+		msg.Data = proto.SetBody(msg.Data, value)
+	}),
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/buger/gor/processor"
+)
+
+func newTestScriptProcessor(t *testing.T, script string) *ScriptProcessor {
+	f, err := ioutil.TempFile("", "gor_script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(script)
+	f.Close()
+
+	return NewScriptProcessor(f.Name())
+}
+
+func TestScriptProcessor(t *testing.T) {
+	p := newTestScriptProcessor(t, `
+		function on_request(req)
+			if req:path() == "/drop" then
+				return
+			end
+
+			if req:path() == "/inject" then
+				return req, gor.request("GET /injected HTTP/1.1\r\n\r\n")
+			end
+
+			req:set_header("X-Src", req:meta("src"))
+			req:set_param("a", "2")
+			req:set_body("updated")
+			return req
+		end
+
+		function on_response(resp)
+			error("failed")
+		end
+	`)
+
+	out := p.Process(&processor.Message{Type: RequestPayload, Meta: map[string]string{"src": "10.0.0.1"}, Data: []byte("POST /?a=1 HTTP/1.1\r\nContent-Length: 7\r\n\r\na=1&b=2")})
+	if len(out) != 1 {
+		t.Fatal("Should return modified request")
+	}
+
+	if !bytes.Equal(out[0].Data, []byte("POST /?a=2 HTTP/1.1\r\nX-Src: 10.0.0.1\r\nContent-Length: 7\r\n\r\nupdated")) {
+		t.Errorf("Wrong request: %q", out[0].Data)
+	}
+
+	if out := p.Process(&processor.Message{Type: RequestPayload, Data: []byte("GET /drop HTTP/1.1\r\n\r\n")}); len(out) != 0 {
+		t.Error("Request should be dropped")
+	}
+
+	out = p.Process(&processor.Message{Type: RequestPayload, Data: []byte("GET /inject HTTP/1.1\r\n\r\n")})
+	if len(out) != 2 || !bytes.Equal(out[1].Data, []byte("GET /injected HTTP/1.1\r\n\r\n")) {
+		t.Error("Request should be injected", out)
+	}
+
+	// Script errors should not drop traffic
+	if out := p.Process(&processor.Message{Type: ResponsePayload, Data: []byte("HTTP/1.1 200 OK\r\n\r\n")}); len(out) != 1 {
+		t.Error("Response should pass if script failed")
+	}
+
+	// No hook defined
+	if out := p.Process(&processor.Message{Type: ReplayedResponsePayload, Data: []byte("HTTP/1.1 200 OK\r\n\r\n")}); len(out) != 1 {
+		t.Error("Response should pass if hook not defined")
+	}
+}
+
+func TestScriptProcessorTokenModifier(t *testing.T) {
+	p := NewScriptProcessor("./examples/middleware/token_modifier.lua")
+
+	p.Process(&processor.Message{Type: RequestPayload, ID: []byte("1"), Data: []byte("GET /token HTTP/1.1\r\n\r\n")})
+	p.Process(&processor.Message{Type: ResponsePayload, ID: []byte("1"), Data: []byte("HTTP/1.1 200 OK\r\n\r\noriginal")})
+	p.Process(&processor.Message{Type: ReplayedResponsePayload, ID: []byte("1"), Data: []byte("HTTP/1.1 200 OK\r\n\r\nreplayed")})
+
+	out := p.Process(&processor.Message{Type: RequestPayload, ID: []byte("2"), Data: []byte("GET /secure?token=original HTTP/1.1\r\n\r\n")})
+
+	if len(out) != 1 || !bytes.Equal(out[0].Data, []byte("GET /secure?token=replayed HTTP/1.1\r\n\r\n")) {
+		t.Error("Token should be rewritten", out)
+	}
+}
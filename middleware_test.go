@@ -8,8 +8,10 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -113,7 +115,7 @@ func TestEchoMiddleware(t *testing.T) {
 
 	quit := make(chan int)
 
-	Settings.middleware = "./examples/middleware/echo.sh"
+	Settings.middleware = MultiOption{"./examples/middleware/echo.sh"}
 
 	// Catch traffic from one service
 	input := NewRAWInput(from.Listener.Addr().String(), testRawExpire)
@@ -144,7 +146,7 @@ func TestEchoMiddleware(t *testing.T) {
 	close(quit)
 	time.Sleep(200 * time.Millisecond)
 
-	Settings.middleware = ""
+	Settings.middleware = nil
 }
 
 func TestTokenMiddleware(t *testing.T) {
@@ -171,7 +173,7 @@ func TestTokenMiddleware(t *testing.T) {
 
 	quit := make(chan int)
 
-	Settings.middleware = "go run ./examples/middleware/token_modifier.go"
+	Settings.middleware = MultiOption{"go run ./examples/middleware/token_modifier.go"}
 
 	fromAddr := strings.Replace(from.Listener.Addr().String(), "[::]", "127.0.0.1", -1)
 	// Catch traffic from one service
@@ -212,5 +214,176 @@ func TestTokenMiddleware(t *testing.T) {
 	wg.Wait()
 	close(quit)
 	time.Sleep(100 * time.Millisecond)
-	Settings.middleware = ""
+	Settings.middleware = nil
+}
+
+func TestSplitCommand(t *testing.T) {
+	cases := []struct {
+		command string
+		args    []string
+	}{
+		{"echo.sh", []string{"echo.sh"}},
+		{"  go run  token_modifier.go ", []string{"go", "run", "token_modifier.go"}},
+		{`sh -c 'read line; echo "$line"'`, []string{"sh", "-c", `read line; echo "$line"`}},
+		{`./modifier --name "a \"b\" c" d\ e ''`, []string{"./modifier", "--name", `a "b" c`, "d e", ""}},
+	}
+
+	for _, c := range cases {
+		args, err := splitCommand(c.command)
+
+		if err != nil || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("Wrong arguments for %s: %q %v", c.command, args, err)
+		}
+	}
+
+	if _, err := splitCommand(`echo "a`); err == nil {
+		t.Error("Should return error on unterminated quote")
+	}
+}
+
+// readMiddleware reads single message from middleware, or fails after timeout
+func readMiddleware(t *testing.T, m *Middleware) []byte {
+	result := make(chan []byte, 1)
+
+	go func() {
+		buf := make([]byte, 1024)
+		n, _ := m.Read(buf)
+		result <- buf[:n]
+	}()
+
+	select {
+	case buf := <-result:
+		return buf
+	case <-time.After(2 * time.Second):
+		t.Fatal("Middleware did not reply")
+	}
+
+	return nil
+}
+
+func TestMiddlewareRestart(t *testing.T) {
+	// Process exits after replying to the first message
+	m := NewMiddleware(`sh -c 'read line; echo "$line"'`, &MiddlewareConfig{})
+	defer m.Close()
+
+	input := NewTestInput()
+	m.ReadFromPlugin(input)
+
+	input.EmitGET()
+	if !bytes.Equal(payloadBody(readMiddleware(t, m)), []byte("GET / HTTP/1.1\r\n\r\n")) {
+		t.Error("Should receive echoed message")
+	}
+
+	// Wait for restart
+	for i := 0; atomic.LoadInt64(&m.restarts) == 0 || atomic.LoadInt32(&m.running) == 0; i++ {
+		if i > 100 {
+			t.Fatal("Middleware should be restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	input.EmitPOST()
+	if !bytes.Equal(proto.Method(payloadBody(readMiddleware(t, m))), []byte("POST")) {
+		t.Error("Restarted middleware should receive messages")
+	}
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	// Process never replies
+	m := NewMiddleware("sh -c 'cat > /dev/null'", &MiddlewareConfig{timeout: 50 * time.Millisecond, timeoutPolicy: middlewarePolicyPass})
+	defer m.Close()
+
+	input := NewTestInput()
+	m.ReadFromPlugin(input)
+
+	input.EmitGET()
+	if !bytes.Equal(payloadBody(readMiddleware(t, m)), []byte("GET / HTTP/1.1\r\n\r\n")) {
+		t.Error("Original message should be passed on timeout")
+	}
+
+	if atomic.LoadInt64(&m.timeouts) != 1 || !strings.HasPrefix(m.Health(), "1,1,0,1,0,0") {
+		t.Error("Wrong health stats:", m.Health())
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	input := NewTestInput()
+	output := NewTestOutput(func(data []byte) {
+		if !bytes.Equal(payloadBody(data), []byte("GET /b HTTP/1.1\r\n\r\n")) {
+			t.Errorf("Message should pass both middlewares: %q", data)
+		}
+		wg.Done()
+	})
+
+	// Rewrites hex encoded "/a" path to "/b"
+	Settings.middleware = MultiOption{"cat", `sed -u s/2f61/2f62/`}
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = []io.Writer{output}
+
+	go Start(quit)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		input.data <- []byte("GET /a HTTP/1.1\r\n\r\n")
+	}
+
+	wg.Wait()
+	close(quit)
+
+	Settings.middleware = nil
+}
+
+func TestMiddlewareLateReply(t *testing.T) {
+	// Process replies after timeout
+	m := NewMiddleware(`sh -c 'while read line; do sleep 0.2; echo "$line"; done'`, &MiddlewareConfig{timeout: 50 * time.Millisecond, timeoutPolicy: middlewarePolicyPass})
+	defer m.Close()
+
+	input := NewTestInput()
+	m.ReadFromPlugin(input)
+
+	input.EmitGET()
+	readMiddleware(t, m)
+
+	select {
+	case buf := <-m.data:
+		t.Errorf("Late reply should not be emitted after timeout policy applied: %q", buf)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if atomic.LoadInt64(&m.received) != 1 || atomic.LoadInt64(&m.timeouts) != 1 {
+		t.Error("Wrong health stats:", m.Health())
+	}
+}
+
+func TestMiddlewareExitWithPending(t *testing.T) {
+	// Process exits without replying, timeout is much longer than test
+	m := NewMiddleware(`sh -c 'read line'`, &MiddlewareConfig{timeout: time.Minute, timeoutPolicy: middlewarePolicyPass})
+	defer m.Close()
+
+	input := NewTestInput()
+	m.ReadFromPlugin(input)
+
+	input.EmitGET()
+	if !bytes.Equal(payloadBody(readMiddleware(t, m)), []byte("GET / HTTP/1.1\r\n\r\n")) {
+		t.Error("Original message should be passed when process exits")
+	}
+
+	if atomic.LoadInt64(&m.timeouts) != 0 || atomic.LoadInt64(&m.failed) != 1 {
+		t.Error("Wrong health stats:", m.Health())
+	}
+}
+
+func TestMiddlewareSharedConfig(t *testing.T) {
+	config := &MiddlewareConfig{}
+
+	m := NewMiddleware("cat", config)
+	defer m.Close()
+
+	if config.timeoutPolicy != "" || m.config.timeoutPolicy != middlewarePolicyDrop {
+		t.Error("Defaults should be set on middleware own config copy")
+	}
 }
@@ -1,33 +1,320 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+
+	defaultFileFlushInterval = time.Second
+	// Minimum interval between rotation checks, so tiny rotate intervals do not cause busy loop
+	minFileRotateCheckInterval = 100 * time.Millisecond
 )
 
+// FileOutputConfig holds options for splitting output into chunks and compression
+type FileOutputConfig struct {
+	sizeLimit      DataSize
+	rotateInterval time.Duration
+	maxFiles       int
+
+	compression   string
+	flushInterval time.Duration
+
+	// Write sidecar index, which allows to replay only specific time range. Not supported for compressed files.
+	index bool
+}
+
+// compressor is implemented by both gzip and zstd writers
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
 // FileOutput output plugin
+//
+// Path can contain strftime-like placeholders, which get resolved each time new chunk created:
+//
+//	%Y - year, %m - month, %d - day, %H - hour, %M - minute, %S - second, %i - chunk index
+//
+// If size limit or rotate interval specified, current file get closed and new one opened when limit reached.
+// For compressed files size limit applies to uncompressed data.
+//
+// Writes are buffered and flushed to disk every `flushInterval`.
 type FileOutput struct {
-	path string
-	file *os.File
+	mu sync.Mutex
+
+	pathTemplate string
+	path         string
+	file         *os.File
+	compressor   compressor
+	writer       *bufio.Writer
+	encoder      *payloadEncoder
+	index        *fileIndexWriter
+	config       *FileOutputConfig
+
+	chunkSize  int64
+	chunkStart time.Time
+	chunkIndex int
+	chunks     []string
 }
 
 // NewFileOutput constructor for FileOutput, accepts path
-func NewFileOutput(path string) io.Writer {
+func NewFileOutput(path string, config *FileOutputConfig) io.Writer {
 	o := new(FileOutput)
-	o.path = path
-	o.init(path)
+	o.pathTemplate = path
+
+	// Config shared between all file outputs, and defaults below depend on path
+	c := *config
+	o.config = &c
+
+	if o.config.compression == "" {
+		o.config.compression = compressionFromPath(path)
+	}
+
+	if o.config.flushInterval == 0 {
+		o.config.flushInterval = defaultFileFlushInterval
+	}
+
+	o.init()
+
+	if o.config.rotateInterval > 0 {
+		go o.rotateTicker()
+	}
+
+	go o.flushTicker()
 
 	return o
 }
 
-func (o *FileOutput) init(path string) {
+// compressionFromPath detects compression based on file extension
+func compressionFromPath(path string) string {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return compressionGzip
+	case ".zst":
+		return compressionZstd
+	default:
+		return ""
+	}
+}
+
+func (o *FileOutput) init() {
 	var err error
 
-	o.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	o.path = o.filename(time.Now())
+	o.file, err = os.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 
 	if err != nil {
-		log.Fatal(o, "Cannot open file %q. Error: %s", path, err)
+		log.Fatal(o, "Cannot open file %q. Error: %s", o.path, err)
+	}
+
+	var w io.Writer = o.file
+
+	switch o.config.compression {
+	case compressionGzip:
+		o.compressor = gzip.NewWriter(o.file)
+	case compressionZstd:
+		o.compressor, err = zstd.NewWriter(o.file)
+	case "":
+		o.compressor = nil
+	default:
+		log.Fatal(o, "Unknown compression: ", o.config.compression)
+	}
+
+	if err != nil {
+		log.Fatal(o, "Cannot initialize compression. Error: ", err)
+	}
+
+	if o.compressor != nil {
+		w = o.compressor
+	}
+
+	o.writer = bufio.NewWriterSize(w, 64*1024)
+	o.encoder = newPayloadEncoder(o.writer, Settings.framing, Settings.framingChecksum)
+
+	// Offsets inside compressed stream can't be used for seeking
+	if o.config.index && o.compressor == nil {
+		if o.index, err = newFileIndexWriter(fileIndexPath(o.path)); err != nil {
+			log.Println("Cannot create index file:", err)
+		}
+	}
+
+	o.chunkSize = 0
+	o.chunkStart = time.Now()
+	o.chunks = append(o.chunks, o.path)
+
+	// Remove oldest chunks if we keep only limited number of files
+	if o.config.maxFiles > 0 {
+		for len(o.chunks) > o.config.maxFiles {
+			if err := os.Remove(o.chunks[0]); err != nil {
+				log.Println("Cannot remove rotated file:", err)
+			}
+			os.Remove(fileIndexPath(o.chunks[0]))
+			o.chunks = o.chunks[1:]
+		}
+	}
+}
+
+// filename resolves path template for the current chunk
+func (o *FileOutput) filename(t time.Time) string {
+	r := strings.NewReplacer(
+		"%Y", t.Format("2006"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%H", t.Format("15"),
+		"%M", t.Format("04"),
+		"%S", t.Format("05"),
+		"%i", strconv.Itoa(o.chunkIndex),
+	)
+	name := r.Replace(o.pathTemplate)
+
+	// Template may resolve to the name of earlier chunk (e.g. no placeholders, rotation within the same second,
+	// or `%H` repeating next day). In this case add chunk index before extension, so we never overwrite chunk
+	// which already closed.
+	if o.chunkIndex > 0 && o.chunkExists(name) {
+		ext := filepath.Ext(name)
+		name = name[:len(name)-len(ext)] + "_" + strconv.Itoa(o.chunkIndex) + ext
+	}
+
+	return name
+}
+
+// chunkExists tells if chunk with given name was written by this output and not removed
+func (o *FileOutput) chunkExists(name string) bool {
+	for _, chunk := range o.chunks {
+		if chunk == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flush writes buffered data to the disk. Should be called under lock.
+func (o *FileOutput) flush() (err error) {
+	if err = o.writer.Flush(); err != nil {
+		return
+	}
+
+	if o.compressor != nil {
+		if err = o.compressor.Flush(); err != nil {
+			return
+		}
+	}
+
+	if o.index != nil {
+		err = o.index.Flush()
+	}
+
+	return
+}
+
+// closeFile flushes all buffers and closes current chunk. Should be called under lock.
+func (o *FileOutput) closeFile() (err error) {
+	if o.index != nil {
+		if err := o.index.Close(); err != nil {
+			log.Println("Error while closing index file:", o.index.path, err)
+		}
+		o.index = nil
+	}
+
+	if err = o.writer.Flush(); err != nil {
+		o.file.Close()
+		return
+	}
+
+	// Closing compressor writes stream footer
+	if o.compressor != nil {
+		if err = o.compressor.Close(); err != nil {
+			o.file.Close()
+			return
+		}
+	}
+
+	return o.file.Close()
+}
+
+// rotate closes current chunk and opens new one. Should be called under lock.
+func (o *FileOutput) rotate() {
+	if err := o.closeFile(); err != nil {
+		log.Println("Error while closing file chunk:", o.path, err)
+	}
+
+	Debug("[OUTPUT-FILE] Rotated file:", o.path)
+
+	o.chunkIndex++
+	o.init()
+}
+
+func (o *FileOutput) needRotate(size int) bool {
+	// Never rotate empty chunks
+	if o.chunkSize == 0 {
+		return false
+	}
+
+	if o.config.sizeLimit > 0 && o.chunkSize+int64(size) > int64(o.config.sizeLimit) {
+		return true
+	}
+
+	if o.config.rotateInterval > 0 && time.Since(o.chunkStart) >= o.config.rotateInterval {
+		return true
+	}
+
+	return false
+}
+
+// rotateTicker ensures that chunks get closed in time even if there is no new traffic
+func (o *FileOutput) rotateTicker() {
+	interval := o.config.rotateInterval / 10
+	if interval < minFileRotateCheckInterval {
+		interval = minFileRotateCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		o.mu.Lock()
+		if o.file == nil {
+			o.mu.Unlock()
+			return
+		}
+
+		if o.needRotate(0) {
+			o.rotate()
+		}
+		o.mu.Unlock()
+	}
+}
+
+func (o *FileOutput) flushTicker() {
+	ticker := time.NewTicker(o.config.flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		o.mu.Lock()
+		if o.file == nil {
+			o.mu.Unlock()
+			return
+		}
+
+		if err := o.flush(); err != nil {
+			log.Println("Error while flushing file:", o.path, err)
+		}
+		o.mu.Unlock()
 	}
 }
 
@@ -36,12 +323,55 @@ func (o *FileOutput) Write(data []byte) (n int, err error) {
 		return len(data), nil
 	}
 
-	o.file.Write(data)
-	o.file.Write([]byte(payloadSeparator))
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	frame := o.encoder.Frame(data)
+
+	if o.needRotate(len(frame)) {
+		o.rotate()
+		// New chunk has its own encoder
+		frame = o.encoder.Frame(data)
+	}
+
+	if o.index != nil && isRequestPayload(data) {
+		meta := payloadMeta(data)
+
+		if len(meta) > 2 {
+			ts, _ := strconv.ParseInt(string(meta[2]), 10, 64)
+			o.index.Add(ts, o.chunkSize)
+		}
+	}
+
+	n, err = o.encoder.WriteFrame(frame)
+	o.chunkSize += int64(n)
+
+	if err != nil {
+		return 0, err
+	}
 
 	return len(data), nil
 }
 
+// Close flushes buffered data and closes current chunk
+func (o *FileOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return nil
+	}
+
+	err := o.closeFile()
+	o.file = nil
+
+	return err
+}
+
 func (o *FileOutput) String() string {
-	return "File output: " + o.path
+	return "File output: " + o.pathTemplate
 }
@@ -1,20 +1,41 @@
 package main
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestFileOutput(t *testing.T) {
+	testFileOutputReplay(t, "/tmp/test_requests.gor", &FileOutputConfig{})
+}
+
+func TestFileOutputGzip(t *testing.T) {
+	testFileOutputReplay(t, "/tmp/test_requests.gor.gz", &FileOutputConfig{})
+}
+
+func TestFileOutputZstd(t *testing.T) {
+	testFileOutputReplay(t, "/tmp/test_requests.gor", &FileOutputConfig{compression: compressionZstd})
+}
+
+func testFileOutputReplay(t *testing.T, path string, config *FileOutputConfig) {
 	wg := new(sync.WaitGroup)
 	quit := make(chan int)
 
 	input := NewTestInput()
-	output := NewFileOutput("/tmp/test_requests.gor")
+	output := NewFileOutput(path, config)
+	// Used to track when all payloads written to file
+	written := NewTestOutput(func(data []byte) {
+		wg.Done()
+	})
 
 	Plugins.Inputs = []io.Reader{input}
-	Plugins.Outputs = []io.Writer{output}
+	Plugins.Outputs = []io.Writer{output, written}
 
 	go Start(quit)
 
@@ -23,20 +44,175 @@ func TestFileOutput(t *testing.T) {
 		input.EmitGET()
 		input.EmitPOST()
 	}
+	wg.Wait()
 	close(quit)
 
+	// Flush buffers and close compression stream, the way it happens on shutdown
+	closeOutputs()
+
 	quit = make(chan int)
 
-	input2 := NewFileInput("/tmp/test_requests.gor")
+	input2 := NewFileInput(path, &FileInputConfig{})
 	output2 := NewTestOutput(func(data []byte) {
+		if !bytes.HasSuffix(data, []byte("HTTP/1.1\r\n\r\n")) && !bytes.HasSuffix(data, []byte("a=1&b=2")) {
+			t.Error("Wrong payload:", string(data))
+		}
 		wg.Done()
 	})
 
 	Plugins.Inputs = []io.Reader{input2}
 	Plugins.Outputs = []io.Writer{output2}
 
+	wg.Add(200)
 	go Start(quit)
 
 	wg.Wait()
 	close(quit)
 }
+
+func TestFileOutputSizeRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_rotation")
+	defer os.RemoveAll(dir)
+
+	output := NewFileOutput(filepath.Join(dir, "requests-%i.gor"), &FileOutputConfig{sizeLimit: 200}).(*FileOutput)
+
+	payload := []byte("1 1 1\nGET / HTTP/1.1\r\n\r\n")
+
+	for i := 0; i < 20; i++ {
+		output.Write(payload)
+	}
+	output.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "requests-*.gor"))
+
+	if len(files) < 2 {
+		t.Fatal("Should create multiple chunks:", files)
+	}
+
+	for _, f := range files {
+		if stat, _ := os.Stat(f); stat.Size() > 200 {
+			t.Error("Chunk should not exceed size limit:", f, stat.Size())
+		}
+	}
+}
+
+func TestFileOutputMaxFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_rotation")
+	defer os.RemoveAll(dir)
+
+	output := NewFileOutput(filepath.Join(dir, "requests.gor"), &FileOutputConfig{sizeLimit: 1, maxFiles: 3}).(*FileOutput)
+
+	for i := 0; i < 10; i++ {
+		output.Write([]byte("1 1 1\nGET / HTTP/1.1\r\n\r\n"))
+	}
+	output.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "requests*.gor"))
+
+	if len(files) != 3 {
+		t.Error("Should keep only 3 latest files:", files)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "requests_9.gor")); err != nil {
+		t.Error("Latest chunk should be kept", err)
+	}
+}
+
+func TestFileOutputIntervalRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_rotation")
+	defer os.RemoveAll(dir)
+
+	output := NewFileOutput(filepath.Join(dir, "requests.gor"), &FileOutputConfig{rotateInterval: 50 * time.Millisecond}).(*FileOutput)
+	output.Write([]byte("1 1 1\nGET / HTTP/1.1\r\n\r\n"))
+
+	// Chunk should be closed by timer even if there is no new traffic
+	time.Sleep(150 * time.Millisecond)
+
+	output.mu.Lock()
+	index := output.chunkIndex
+	output.mu.Unlock()
+
+	if index != 1 {
+		t.Error("Should rotate non empty chunk only once:", index)
+	}
+
+	output.Close()
+}
+
+func TestFileOutputTinyRotateInterval(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_rotation")
+	defer os.RemoveAll(dir)
+
+	// Ticker interval derived from rotate interval should not become zero
+	output := NewFileOutput(filepath.Join(dir, "requests.gor"), &FileOutputConfig{rotateInterval: 5 * time.Nanosecond}).(*FileOutput)
+	output.Write([]byte("1 1 1\nGET / HTTP/1.1\r\n\r\n"))
+
+	time.Sleep(150 * time.Millisecond)
+
+	output.Close()
+}
+
+func TestFileOutputSharedConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_config")
+	defer os.RemoveAll(dir)
+
+	config := &FileOutputConfig{}
+
+	gzipped := NewFileOutput(filepath.Join(dir, "requests.gor.gz"), config).(*FileOutput)
+	plain := NewFileOutput(filepath.Join(dir, "requests.gor"), config).(*FileOutput)
+	defer gzipped.Close()
+	defer plain.Close()
+
+	if plain.compressor != nil || gzipped.compressor == nil {
+		t.Error("Compression detected from path should not leak to other outputs")
+	}
+
+	if config.compression != "" || config.flushInterval != 0 {
+		t.Error("Shared config should not be modified")
+	}
+}
+
+func TestFileOutputSizeRotationBinary(t *testing.T) {
+	Settings.framing, Settings.framingChecksum = framingBinary, true
+	defer func() { Settings.framing, Settings.framingChecksum = "", false }()
+
+	dir, _ := ioutil.TempDir("", "gor_rotation")
+	defer os.RemoveAll(dir)
+
+	payload := []byte("1 1 1\nGET / HTTP/1.1\r\n\r\n")
+	frameSize := len(newPayloadEncoder(ioutil.Discard, framingBinary, true).Frame(payload))
+
+	// Exactly 3 frames fit into chunk
+	sizeLimit := framingHeaderSize + 3*frameSize
+	output := NewFileOutput(filepath.Join(dir, "requests-%i.gor"), &FileOutputConfig{sizeLimit: DataSize(sizeLimit)}).(*FileOutput)
+
+	for i := 0; i < 4; i++ {
+		output.Write(payload)
+	}
+	output.Close()
+
+	if stat, err := os.Stat(filepath.Join(dir, "requests-0.gor")); err != nil || stat.Size() != int64(sizeLimit) {
+		t.Error("Chunk should be filled up to size limit", err)
+	}
+
+	if stat, err := os.Stat(filepath.Join(dir, "requests-1.gor")); err != nil || stat.Size() != int64(framingHeaderSize+frameSize) {
+		t.Error("Payload which does not fit should go to the next chunk", err)
+	}
+}
+
+func TestFileOutputChunkNameCollision(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gor_rotation")
+	defer os.RemoveAll(dir)
+
+	// Template resolves to the name of chunk before previous one, like `%H` after a day
+	o := &FileOutput{pathTemplate: filepath.Join(dir, "a.gor"), chunkIndex: 2, chunks: []string{filepath.Join(dir, "a.gor"), filepath.Join(dir, "b.gor")}}
+
+	if name := o.filename(time.Now()); name != filepath.Join(dir, "a_2.gor") {
+		t.Error("Should not reuse name of earlier chunk:", name)
+	}
+
+	o.chunks = o.chunks[1:]
+	if name := o.filename(time.Now()); name != filepath.Join(dir, "a.gor") {
+		t.Error("Name of removed chunk can be reused:", name)
+	}
+}
@@ -30,6 +30,10 @@ type HTTPOutputConfig struct {
 	Debug bool
 
 	TrackResponses bool
+
+	// Session key used to keep cookies set by replayed server, see CookieJar
+	cookieSession string
+	cookieStrip   bool
 }
 
 // HTTPOutput plugin manage pool of workers which send request to replayed server
@@ -54,6 +58,8 @@ type HTTPOutput struct {
 	queueStats *GorStat
 
 	elasticSearch *ESPlugin
+
+	cookies *CookieJar
 }
 
 // NewHTTPOutput constructor for HTTPOutput
@@ -84,7 +90,11 @@ func NewHTTPOutput(address string, config *HTTPOutputConfig) io.Writer {
 		o.elasticSearch.Init(o.config.elasticSearch)
 	}
 
-	if len(Settings.middleware) > 0 {
+	if o.config.cookieSession != "" {
+		o.cookies = NewCookieJar(o.config.cookieSession, o.config.cookieStrip)
+	}
+
+	if responsesRequired() {
 		o.config.TrackResponses = true
 	}
 
@@ -175,7 +185,7 @@ func (o *HTTPOutput) Read(data []byte) (int, error) {
 
 	Debug("[OUTPUT-HTTP] Received response:", string(resp.payload))
 
-	header := payloadHeader(ReplayedResponsePayload, resp.uuid, resp.roundTripTime)
+	header := payloadHeader(ReplayedResponsePayload, resp.uuid, resp.roundTripTime, metaField(metaTarget, o.address))
 	copy(data[0:len(header)], header)
 	copy(data[len(header):], resp.payload)
 
@@ -191,6 +201,12 @@ func (o *HTTPOutput) sendRequest(client *HTTPClient, request []byte) {
 		return
 	}
 
+	var session string
+	if o.cookies != nil {
+		session = o.cookies.Session(request)
+		body = o.cookies.Apply(session, body)
+	}
+
 	start := time.Now()
 	resp, err := client.Send(body)
 	stop := time.Now()
@@ -199,6 +215,10 @@ func (o *HTTPOutput) sendRequest(client *HTTPClient, request []byte) {
 		Debug("Request error:", err)
 	}
 
+	if o.cookies != nil {
+		o.cookies.Update(session, resp)
+	}
+
 	if o.config.TrackResponses {
 		o.responses <- response{resp, uuid, stop.UnixNano() - start.UnixNano()}
 	}
@@ -152,3 +152,26 @@ func BenchmarkHTTPOutput(b *testing.B) {
 
 	close(quit)
 }
+
+func TestHTTPOutputCookieSession(t *testing.T) {
+	received := make(chan string, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received <- req.Header.Get("Cookie")
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "staging"})
+	}))
+	defer server.Close()
+
+	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{workers: 1, cookieSession: "cookie:sid"})
+
+	output.Write([]byte("1 a 1\nGET / HTTP/1.1\r\nCookie: sid=production\r\n\r\n"))
+	if cookie := <-received; cookie != "sid=production" {
+		t.Error("First request should use original cookie", cookie)
+	}
+
+	// Single worker sends requests one by one, so response of the first one is already processed
+	output.Write([]byte("1 b 1\nGET / HTTP/1.1\r\nCookie: sid=production\r\n\r\n"))
+	if cookie := <-received; cookie != "sid=staging" {
+		t.Error("Following requests should use cookie set by replayed server", cookie)
+	}
+}
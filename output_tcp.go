@@ -45,9 +45,11 @@ func (o *TCPOutput) worker() {
 
 	defer conn.Close()
 
+	// Each connection starts new stream, with its own framing header
+	encoder := newPayloadEncoder(conn, Settings.framing, Settings.framingChecksum)
+
 	for {
-		conn.Write(<-o.buf)
-		_, err := conn.Write([]byte(payloadSeparator))
+		_, err := encoder.Encode(<-o.buf)
 
 		if err != nil {
 			log.Println("Worker failed on write, exitings and starting new worker")
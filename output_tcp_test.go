@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"io"
 	"log"
 	"net"
@@ -47,12 +46,14 @@ func startTCP(cb func([]byte)) net.Listener {
 			defer conn.Close()
 
 			go func() {
-				reader := bufio.NewReader(conn)
-				scanner := bufio.NewScanner(reader)
-				scanner.Split(payloadScanner)
-
-				for scanner.Scan() {
-					cb(scanner.Bytes())
+				decoder, _ := newPayloadDecoder(conn)
+
+				for {
+					buf, err := decoder.Decode()
+					if err != nil {
+						return
+					}
+					cb(buf)
 				}
 			}()
 		}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"io"
+	"log"
 	"reflect"
 	"strings"
 	"time"
+
+	"github.com/buger/gor/processor"
+	"github.com/buger/gor/proto"
 )
 
 // InOutPlugins struct for holding references to plugins
 type InOutPlugins struct {
 	Inputs  []io.Reader
 	Outputs []io.Writer
+
+	// In-process middleware, applied to each payload in order
+	Processors []processor.Processor
 }
 
 // Plugins holds all the plugin objects
@@ -69,8 +76,34 @@ func registerPlugin(constructor interface{}, options ...interface{}) {
 	}
 }
 
+// closeOutputs closes outputs which support it, so buffered data get flushed
+func closeOutputs() {
+	for _, out := range Plugins.Outputs {
+		if c, ok := out.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Println("Error while closing output", out, err)
+			}
+		}
+	}
+}
+
+// responsesRequired tells if outputs should return replayed responses
+func responsesRequired() bool {
+	return len(Settings.middleware) > 0 || len(Settings.processors) > 0 || Settings.middlewareScript != "" || len(Settings.aliases) > 0 || Settings.correlationConfig.ttl > 0 || len(Settings.responseFilterConfig.replayedFilters) > 0
+}
+
 // InitPlugins specify and initialize all available plugins
 func InitPlugins() {
+	for _, method := range Settings.customMethods {
+		proto.AddMethod(method)
+	}
+
+	if Settings.correlationConfig.ttl > 0 {
+		Correlations = NewCorrelationStore(&Settings.correlationConfig)
+	}
+
+	ResponseFilters = NewResponseFilter(&Settings.responseFilterConfig, Settings.modifierConfig.decodeBody)
+
 	for _, options := range Settings.inputDummy {
 		registerPlugin(NewDummyInput, options)
 	}
@@ -92,17 +125,30 @@ func InitPlugins() {
 	}
 
 	for _, options := range Settings.inputFile {
-		registerPlugin(NewFileInput, options)
+		registerPlugin(NewFileInput, options, &Settings.inputFileConfig)
 	}
 
 	for _, options := range Settings.outputFile {
-		registerPlugin(NewFileOutput, options)
+		registerPlugin(NewFileOutput, options, &Settings.outputFileConfig)
 	}
 
 	for _, options := range Settings.inputHTTP {
 		registerPlugin(NewHTTPInput, options)
 	}
 
+	// Aliases applied first, so scripts and processors see substituted values
+	if len(Settings.aliases) > 0 {
+		Plugins.Processors = append(Plugins.Processors, NewAliasProcessor(Settings.aliases))
+	}
+
+	if Settings.middlewareScript != "" {
+		Plugins.Processors = append(Plugins.Processors, NewScriptProcessor(Settings.middlewareScript))
+	}
+
+	for _, options := range Settings.processors {
+		Plugins.Processors = append(Plugins.Processors, newProcessor(options))
+	}
+
 	// If we explicitly set Host header http output should not rewrite it
 	// Fix: https://github.com/buger/gor/issues/174
 	for _, header := range Settings.modifierConfig.headers {
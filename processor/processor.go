@@ -0,0 +1,117 @@
+/*
+Package processor provides API for in-process middleware.
+
+Processors are compiled into Gor binary and work without spawning external process and encoding payloads.
+To build Gor with custom processor, register it in `init` function of your package:
+
+	package ratelimit
+
+	import "github.com/buger/gor/processor"
+
+	func init() {
+		processor.Register("ratelimit", func(options string) (processor.Processor, error) {
+			return newLimiter(options)
+		})
+	}
+
+And add blank import of your package to Gor main package, e.g. `processors.go` file next to `gor.go`:
+
+	package main
+
+	import _ "github.com/yourteam/ratelimit"
+
+Registered processors can be enabled using `--middleware-processor name[:options]` option.
+*/
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Message types, same as first value of payload header
+const (
+	Request          = '1'
+	Response         = '2'
+	ReplayedResponse = '3'
+)
+
+// Message represents single payload: request, original response or replayed response
+type Message struct {
+	Type byte
+	// Unique request id, same for request and its responses
+	ID []byte
+	// For requests - start time, for responses - round-trip time
+	Timing int64
+	// Optional payload meta fields, like `src` or `conn`
+	Meta map[string]string
+	// Raw HTTP payload
+	Data []byte
+}
+
+// Processor handles messages and returns messages which should be passed further.
+// Return nil to drop message, or multiple messages to inject new requests.
+// Process can be called concurrently.
+type Processor interface {
+	Process(msg *Message) []*Message
+}
+
+// Func adapter allows to use ordinary functions as processors
+type Func func(msg *Message) []*Message
+
+// Process calls f(msg)
+func (f Func) Process(msg *Message) []*Message {
+	return f(msg)
+}
+
+// Factory creates new processor instance, options is a string passed after processor name in command line
+type Factory func(options string) (Processor, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes processor available by given name. Panics if name already registered.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("processor: Register factory is nil")
+	}
+
+	if _, dup := factories[name]; dup {
+		panic("processor: Register called twice for " + name)
+	}
+
+	factories[name] = factory
+}
+
+// New creates instance of registered processor
+func New(name, options string) (Processor, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("processor: unknown processor %q (registered: %v)", name, Names())
+	}
+
+	return factory(options)
+}
+
+// Names returns sorted list of registered processors
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
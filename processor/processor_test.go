@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	Register("test-echo", func(options string) (Processor, error) {
+		return Func(func(msg *Message) []*Message {
+			msg.Data = append(msg.Data, options...)
+			return []*Message{msg}
+		}), nil
+	})
+
+	p, err := New("test-echo", "!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := p.Process(&Message{Type: Request, Data: []byte("a")})
+	if len(out) != 1 || !bytes.Equal(out[0].Data, []byte("a!")) {
+		t.Error("Processor should receive options", out)
+	}
+
+	if _, err := New("test-unknown", ""); err == nil {
+		t.Error("Should return error for unknown processor")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Should panic on duplicate registration")
+		}
+	}()
+
+	Register("test-echo", func(options string) (Processor, error) { return nil, nil })
+}
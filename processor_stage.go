@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/gor/processor"
+)
+
+// newProcessor creates registered processor from `name[:options]` string
+func newProcessor(options string) processor.Processor {
+	name, opts := options, ""
+
+	if i := strings.IndexByte(options, ':'); i != -1 {
+		name, opts = options[:i], options[i+1:]
+	}
+
+	p, err := processor.New(name, opts)
+
+	if err != nil {
+		log.Fatal("Cannot initialize processor: ", err)
+	}
+
+	return p
+}
+
+// newProcessorMessage converts payload to processor message
+// Payload data copied, so processors can keep reference to it
+func newProcessorMessage(payload []byte) *processor.Message {
+	meta := payloadMeta(payload)
+	body := payloadBody(payload)
+
+	msg := &processor.Message{
+		Type: meta[0][0],
+		Data: append([]byte(nil), body...),
+	}
+
+	if len(meta) > 1 {
+		msg.ID = append([]byte(nil), meta[1]...)
+	}
+
+	if len(meta) > 2 {
+		msg.Timing, _ = strconv.ParseInt(string(meta[2]), 10, 64)
+	}
+
+	msg.Meta = payloadMetaFields(meta)
+
+	return msg
+}
+
+// processorPayload converts processor message back to payload
+// Requests created by processors may omit type, ID and timing, they get generated.
+func processorPayload(msg *processor.Message) []byte {
+	if msg.Type == 0 {
+		msg.Type = processor.Request
+	}
+
+	if len(msg.ID) == 0 {
+		msg.ID = uuid()
+	}
+
+	if msg.Timing == 0 && msg.Type == processor.Request {
+		msg.Timing = time.Now().UnixNano()
+	}
+
+	return append(payloadHeader(msg.Type, msg.ID, msg.Timing, metaFields(msg.Meta)...), msg.Data...)
+}
+
+// processPayload passes payload through all processors, output of each processor is input of the next one
+func processPayload(processors []processor.Processor, payload []byte) [][]byte {
+	messages := []*processor.Message{newProcessorMessage(payload)}
+
+	for _, p := range processors {
+		var next []*processor.Message
+
+		for _, msg := range messages {
+			for _, out := range p.Process(msg) {
+				if out != nil {
+					next = append(next, out)
+				}
+			}
+		}
+
+		if messages = next; len(messages) == 0 {
+			return nil
+		}
+	}
+
+	payloads := make([][]byte, len(messages))
+	for i, msg := range messages {
+		payloads[i] = processorPayload(msg)
+	}
+
+	return payloads
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/buger/gor/processor"
+	"github.com/buger/gor/proto"
+)
+
+func TestProcessPayload(t *testing.T) {
+	setHeader := processor.Func(func(msg *processor.Message) []*processor.Message {
+		msg.Data = proto.SetHeader(msg.Data, []byte("X-Processed"), []byte(msg.Meta["src"]))
+		return []*processor.Message{msg}
+	})
+
+	duplicate := processor.Func(func(msg *processor.Message) []*processor.Message {
+		injected := &processor.Message{Data: []byte("GET /injected HTTP/1.1\r\n\r\n")}
+		return []*processor.Message{msg, injected}
+	})
+
+	payloads := processPayload([]processor.Processor{setHeader, duplicate}, []byte("1 abc 1 src=10.0.0.1:80\nGET / HTTP/1.1\r\n\r\n"))
+
+	if len(payloads) != 2 {
+		t.Fatal("Should emit original and injected payloads", len(payloads))
+	}
+
+	if !bytes.Equal(payloads[0], []byte("1 abc 1 src=10.0.0.1:80\nGET / HTTP/1.1\r\nX-Processed: 10.0.0.1:80\r\n\r\n")) {
+		t.Errorf("Wrong processed payload: %q", payloads[0])
+	}
+
+	meta := payloadMeta(payloads[1])
+	if !isRequestPayload(payloads[1]) || len(meta[1]) == 0 || bytes.Equal(meta[2], []byte("0")) {
+		t.Errorf("Injected request should get id and timing: %q", payloads[1])
+	}
+
+	drop := processor.Func(func(msg *processor.Message) []*processor.Message {
+		return nil
+	})
+
+	if processPayload([]processor.Processor{drop, setHeader}, []byte("1 abc 1\nGET / HTTP/1.1\r\n\r\n")) != nil {
+		t.Error("Dropped payload should not reach next processors")
+	}
+}
+
+func TestEmitterProcessors(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	quit := make(chan int)
+
+	var gets, posts int32
+
+	input := NewTestInput()
+	output := NewTestOutput(func(data []byte) {
+		if bytes.Equal(proto.Method(payloadBody(data)), []byte("POST")) {
+			atomic.AddInt32(&posts, 1)
+		} else {
+			atomic.AddInt32(&gets, 1)
+		}
+		wg.Done()
+	})
+
+	// Drops GET requests, for POST injects additional GET
+	Plugins.Processors = []processor.Processor{processor.Func(func(msg *processor.Message) []*processor.Message {
+		if bytes.Equal(proto.Method(msg.Data), []byte("GET")) {
+			return nil
+		}
+
+		return []*processor.Message{msg, {Data: []byte("GET /injected HTTP/1.1\r\n\r\n")}}
+	})}
+
+	Plugins.Inputs = []io.Reader{input}
+	Plugins.Outputs = []io.Writer{output}
+
+	go Start(quit)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		input.EmitGET()
+		input.EmitPOST()
+	}
+
+	wg.Wait()
+	close(quit)
+
+	Plugins.Processors = nil
+
+	if gets != 10 || posts != 10 {
+		t.Error("Should drop original GET requests and inject new", gets, posts)
+	}
+}
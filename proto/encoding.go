@@ -0,0 +1,163 @@
+package proto
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+)
+
+// ErrUnsupportedEncoding returned if body uses unknown Content-Encoding, or chunked Transfer-Encoding
+var ErrUnsupportedEncoding = errors.New("proto: unsupported content encoding")
+
+// contentEncodings returns list of applied encodings from Content-Encoding header values, in order they were applied.
+// `identity` skipped.
+func contentEncodings(values [][]byte) (encodings [][]byte) {
+	for _, h := range values {
+		for _, e := range bytes.Split(h, []byte(",")) {
+			if e = bytes.ToLower(bytes.TrimSpace(e)); len(e) > 0 && string(e) != "identity" {
+				encodings = append(encodings, e)
+			}
+		}
+	}
+
+	return
+}
+
+func isChunked(transferEncoding []byte) bool {
+	return bytes.Contains(bytes.ToLower(transferEncoding), []byte("chunked"))
+}
+
+// IsEncoded tells if payload body is compressed according to Content-Encoding header
+func IsEncoded(payload []byte) bool {
+	return len(contentEncodings(Headers(payload, []byte("Content-Encoding")))) > 0
+}
+
+// DecodeBody returns body decoded according to Content-Encoding header: gzip, deflate and br supported.
+// If body not encoded, it returned as is.
+func DecodeBody(payload []byte) ([]byte, error) {
+	return NewMessage(payload).DecodeBody()
+}
+
+// EncodeBody encodes body according to Content-Encoding header of payload, and replaces payload body with it.
+// Content-Length updated if present. Returns modified payload.
+func EncodeBody(payload, body []byte) ([]byte, error) {
+	msg := NewMessage(payload)
+	if err := msg.EncodeBody(body); err != nil {
+		return payload, err
+	}
+
+	return msg.Bytes(), nil
+}
+
+// IsEncoded tells if body is compressed according to Content-Encoding header
+func (m *Message) IsEncoded() bool {
+	return len(contentEncodings(m.Headers([]byte("Content-Encoding")))) > 0
+}
+
+// IsChunked tells if body uses chunked Transfer-Encoding
+func (m *Message) IsChunked() bool {
+	return isChunked(m.Header([]byte("Transfer-Encoding")))
+}
+
+// DecodeBody returns body decoded according to Content-Encoding header, see DecodeBody
+func (m *Message) DecodeBody() ([]byte, error) {
+	body := m.Body()
+	encodings := contentEncodings(m.Headers([]byte("Content-Encoding")))
+
+	if len(encodings) == 0 || len(body) == 0 {
+		return body, nil
+	}
+
+	if m.IsChunked() {
+		return nil, ErrUnsupportedEncoding
+	}
+
+	var err error
+
+	// Encodings listed in order they were applied, so decode in reverse order
+	for i := len(encodings) - 1; i >= 0; i-- {
+		if body, err = Decode(encodings[i], body); err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+// EncodeBody encodes body according to Content-Encoding header and replaces message body with it
+func (m *Message) EncodeBody(body []byte) (err error) {
+	encodings := contentEncodings(m.Headers([]byte("Content-Encoding")))
+
+	if len(encodings) > 0 && m.IsChunked() {
+		return ErrUnsupportedEncoding
+	}
+
+	for _, e := range encodings {
+		if body, err = Encode(e, body); err != nil {
+			return err
+		}
+	}
+
+	m.SetBody(body)
+
+	return nil
+}
+
+// Decode decompresses data using given content coding
+func Decode(encoding, data []byte) ([]byte, error) {
+	var r io.Reader
+	var err error
+
+	switch string(encoding) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		// `deflate` should be zlib stream, but some servers send raw deflate data
+		if r, err = zlib.NewReader(bytes.NewReader(data)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(data)), nil
+		}
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+// Encode compresses data using given content coding
+func Encode(encoding, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch string(encoding) {
+	case "gzip", "x-gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,64 @@
+package proto
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data := []byte("Hello world, hello world, hello world")
+
+	for _, e := range []string{"gzip", "deflate", "br"} {
+		encoded, err := Encode([]byte(e), data)
+		if err != nil {
+			t.Fatal(e, err)
+		}
+
+		if decoded, err := Decode([]byte(e), encoded); err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("%s: wrong decoded data %q %v", e, decoded, err)
+		}
+	}
+
+	if _, err := Decode([]byte("compress"), data); err != ErrUnsupportedEncoding {
+		t.Error("Should not support compress", err)
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	gzipped, _ := Encode([]byte("gzip"), []byte("hello"))
+	body, _ := Encode([]byte("br"), gzipped)
+
+	payload := []byte("HTTP/1.1 200 OK\r\nContent-Encoding: gzip, identity\r\nContent-Encoding: br\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body))
+
+	if !IsEncoded(payload) {
+		t.Error("Should be encoded")
+	}
+
+	if decoded, err := DecodeBody(payload); err != nil || string(decoded) != "hello" {
+		t.Errorf("Wrong body: %q %v", decoded, err)
+	}
+
+	payload, err := EncodeBody(payload, []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded, _ := DecodeBody(payload); string(decoded) != "world" {
+		t.Errorf("Wrong body: %q", decoded)
+	}
+
+	if string(Header(payload, []byte("Content-Length"))) != strconv.Itoa(len(Body(payload))) {
+		t.Error("Content-Length should be updated")
+	}
+
+	plain := []byte("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	if decoded, err := DecodeBody(plain); err != nil || string(decoded) != "hello" || IsEncoded(plain) {
+		t.Error("Plain body should be returned as is", err)
+	}
+
+	chunked := []byte("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+	if _, err := DecodeBody(chunked); err != ErrUnsupportedEncoding {
+		t.Error("Chunked body should not be supported", err)
+	}
+}
@@ -0,0 +1,165 @@
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// Errors returned by request and status line parsers
+var (
+	ErrNoLineEnd      = errors.New("proto: first line is not terminated")
+	ErrMalformedLine  = errors.New("proto: malformed first line")
+	ErrUnknownMethod  = errors.New("proto: unknown method")
+	ErrInvalidVersion = errors.New("proto: invalid HTTP version")
+	ErrInvalidStatus  = errors.New("proto: invalid status code")
+)
+
+// Methods recognized by default: RFC 7231, PATCH and WebDAV
+var methods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true, "CONNECT": true, "OPTIONS": true, "TRACE": true,
+	"PATCH":    true,
+	"PROPFIND": true, "PROPPATCH": true, "MKCOL": true, "COPY": true, "MOVE": true, "LOCK": true, "UNLOCK": true,
+	"REPORT": true, "SEARCH": true, "MKCALENDAR": true,
+}
+
+// Length of the longest known method, used to limit method lookup
+var maxMethodLength = 10
+
+// If enabled, any method consisting of valid token characters is accepted
+var anyMethod bool
+
+// AddMethod makes custom method, like `PURGE`, recognized as HTTP request.
+// Special value `*` enables any method consisting of valid token characters.
+// Should be called before processing traffic, it is not thread-safe.
+func AddMethod(method string) {
+	if method == "*" {
+		anyMethod = true
+		return
+	}
+
+	methods[method] = true
+
+	if len(method) > maxMethodLength {
+		maxMethodLength = len(method)
+	}
+}
+
+// IsMethod tells if method is recognized
+func IsMethod(method []byte) bool {
+	if anyMethod {
+		return isToken(method)
+	}
+
+	return methods[string(method)]
+}
+
+// isToken checks that value consist of RFC 7230 `tchar` characters
+func isToken(value []byte) bool {
+	if len(value) == 0 {
+		return false
+	}
+
+	for _, c := range value {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case bytes.IndexByte([]byte("!#$%&'*+-.^_`|~"), c) != -1:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequestLine holds parts of request line: `GET /index.html HTTP/1.1`
+type RequestLine struct {
+	Method  []byte
+	Target  []byte
+	Version []byte
+}
+
+// StatusLine holds parts of response status line: `HTTP/1.1 200 OK`
+type StatusLine struct {
+	Version []byte
+	Status  int
+	Reason  []byte
+}
+
+// firstLine returns first line of payload without CRLF, and position of the next line
+func firstLine(payload []byte) (line []byte, next int, err error) {
+	end := bytes.IndexByte(payload, '\n')
+	if end == -1 {
+		return nil, -1, ErrNoLineEnd
+	}
+
+	line = payload[:end]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	return line, end + 1, nil
+}
+
+func isVersion(version []byte) bool {
+	return len(version) > 5 && bytes.HasPrefix(version, []byte("HTTP/"))
+}
+
+// ParseRequestLine parses request line, returned parts reference payload.
+// Returns position of the next line (start of headers).
+func ParseRequestLine(payload []byte) (r RequestLine, next int, err error) {
+	line, next, err := firstLine(payload)
+	if err != nil {
+		return
+	}
+
+	parts := bytes.Split(line, []byte(" "))
+	if len(parts) != 3 || len(parts[1]) == 0 {
+		return r, -1, ErrMalformedLine
+	}
+
+	r.Method, r.Target, r.Version = parts[0], parts[1], parts[2]
+
+	if !IsMethod(r.Method) {
+		return r, -1, ErrUnknownMethod
+	}
+
+	if !isVersion(r.Version) {
+		return r, -1, ErrInvalidVersion
+	}
+
+	return r, next, nil
+}
+
+// ParseStatusLine parses response status line, returned parts reference payload. Reason is optional.
+// Returns position of the next line (start of headers).
+func ParseStatusLine(payload []byte) (s StatusLine, next int, err error) {
+	line, next, err := firstLine(payload)
+	if err != nil {
+		return
+	}
+
+	parts := bytes.SplitN(line, []byte(" "), 3)
+	if len(parts) < 2 {
+		return s, -1, ErrMalformedLine
+	}
+
+	s.Version = parts[0]
+	if !isVersion(s.Version) {
+		return s, -1, ErrInvalidVersion
+	}
+
+	if len(parts[1]) != 3 {
+		return s, -1, ErrInvalidStatus
+	}
+
+	if s.Status, err = strconv.Atoi(string(parts[1])); err != nil || s.Status < 100 {
+		return s, -1, ErrInvalidStatus
+	}
+
+	if len(parts) == 3 {
+		s.Reason = parts[2]
+	}
+
+	return s, next, nil
+}
@@ -0,0 +1,88 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRequestLine(t *testing.T) {
+	r, next, err := ParseRequestLine([]byte("PATCH /users/1?a=b HTTP/1.1\r\nHost: a\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(r.Method) != "PATCH" || string(r.Target) != "/users/1?a=b" || string(r.Version) != "HTTP/1.1" || next != 29 {
+		t.Errorf("Wrong request line: %q %q %q %d", r.Method, r.Target, r.Version, next)
+	}
+
+	cases := map[string]error{
+		"GET / HTTP/1.1":          ErrNoLineEnd,
+		"GET\r\n":                 ErrMalformedLine,
+		"GET  HTTP/1.1\r\n":       ErrMalformedLine,
+		"GET / HTTP/1.1 x\r\n":    ErrMalformedLine,
+		"PURGE / HTTP/1.1\r\n":    ErrUnknownMethod,
+		"GET / FTP\r\n":           ErrInvalidVersion,
+		"PROPFIND / HTTP/1.1\r\n": nil,
+	}
+
+	for line, expected := range cases {
+		if _, _, err := ParseRequestLine([]byte(line)); err != expected {
+			t.Errorf("%q: expected %v, got %v", line, expected, err)
+		}
+	}
+}
+
+func TestParseStatusLine(t *testing.T) {
+	s, _, err := ParseStatusLine([]byte("HTTP/1.1 404 Not Found\r\n\r\n"))
+	if err != nil || s.Status != 404 || string(s.Reason) != "Not Found" || string(s.Version) != "HTTP/1.1" {
+		t.Errorf("Wrong status line: %v %q %q %v", s.Status, s.Reason, s.Version, err)
+	}
+
+	if s, _, err = ParseStatusLine([]byte("HTTP/1.1 204\r\n\r\n")); err != nil || s.Status != 204 || len(s.Reason) != 0 {
+		t.Error("Reason should be optional", err)
+	}
+
+	for _, line := range []string{"HTTP/1.1 20 OK\r\n", "HTTP/1.1 abc OK\r\n", "HTTP/1.1\r\n", "ICY 200 OK\r\n"} {
+		if _, _, err := ParseStatusLine([]byte(line)); err == nil {
+			t.Errorf("%q: should fail", line)
+		}
+	}
+}
+
+func TestCustomMethods(t *testing.T) {
+	if !IsHTTPPayload([]byte("PATCH / HTTP/1.1\r\n\r\n")) || !IsHTTPPayload([]byte("MKCALENDAR / HTTP/1.1\r\n\r\n")) {
+		t.Error("Should recognize PATCH and WebDAV methods")
+	}
+
+	if IsHTTPPayload([]byte("PURGE / HTTP/1.1\r\n\r\n")) || IsHTTPPayload([]byte("GETX / HTTP/1.1\r\n\r\n")) {
+		t.Error("Should not recognize unknown methods")
+	}
+
+	AddMethod("PURGE")
+	defer delete(methods, "PURGE")
+
+	if !IsHTTPPayload([]byte("PURGE / HTTP/1.1\r\n\r\n")) {
+		t.Error("Should recognize custom method")
+	}
+
+	AddMethod("*")
+	defer func() { anyMethod = false }()
+
+	if !IsHTTPPayload([]byte("X-CUSTOM / HTTP/1.1\r\n\r\n")) || IsHTTPPayload([]byte("BAD\r\nMETHOD / HTTP/1.1\r\n\r\n")) {
+		t.Error("Should recognize any valid method")
+	}
+}
+
+func TestFirstLineMalformed(t *testing.T) {
+	for _, payload := range []string{"", "GET", "GET\r\n\r\n", "HTTP/1.1 200\r\nA: b c\r\n\r\n"} {
+		Method([]byte(payload))
+		Path([]byte(payload))
+		SetPath([]byte(payload), []byte("/a"))
+		SetPathParam([]byte(payload), []byte("a"), []byte("b"))
+		IsHTTPPayload([]byte(payload))
+	}
+
+	if status := Status([]byte("HTTP/1.1 200\r\nA: b c\r\n\r\n")); !bytes.Equal(status, []byte("200")) {
+		t.Errorf("Should find status without reason, got %q", status)
+	}
+}
@@ -0,0 +1,351 @@
+package proto
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// messageHeader holds parsed header field. All slices reference original payload until modified.
+type messageHeader struct {
+	name  []byte
+	value []byte
+	// Original line, including continuation lines and CRLF. Set to nil when header modified.
+	// Malformed lines (without colon) have nil name and kept as is.
+	raw []byte
+}
+
+// Message is parsed view of HTTP request or response payload.
+//
+// Payload is scanned once: first line, headers and body indexed, and all accessors return slices of original payload
+// without copying. Modifications change only index, and new payload built once by Bytes.
+// Unmodified parts keep their original formatting.
+//
+// Message is not thread-safe. Payload should not be modified while Message is used.
+type Message struct {
+	payload []byte
+
+	// First line parts: method, path and version for requests; version, status and reason for responses
+	first, second, third []byte
+	// Original first line including line end, nil if first line modified
+	line []byte
+	// Position after first line, -1 if first line not terminated
+	headersStart int
+
+	headers []messageHeader
+	// Rest of payload after headers: empty line and body. Empty if payload incomplete.
+	tail []byte
+	// Set if body replaced
+	body     []byte
+	bodySet  bool
+	modified bool
+}
+
+// NewMessage parses payload and returns its view
+func NewMessage(payload []byte) *Message {
+	m := &Message{payload: payload, headersStart: -1}
+
+	line, next, err := firstLine(payload)
+	if err != nil {
+		line = payload
+		m.line = payload
+	} else {
+		m.line = payload[:next]
+	}
+
+	if start := bytes.IndexByte(line, ' '); start != -1 {
+		m.first = line[:start]
+
+		if end := bytes.IndexByte(line[start+1:], ' '); end != -1 {
+			m.second = line[start+1 : start+1+end]
+			m.third = line[start+2+end:]
+		} else {
+			// Status line without reason
+			m.second = line[start+1:]
+		}
+	}
+
+	if err != nil {
+		return m
+	}
+
+	m.headersStart = next
+	limit := headersLimit(payload)
+
+	for f, ok := nextHeader(payload, next, limit); ok; f, ok = nextHeader(payload, f.end, limit) {
+		h := messageHeader{raw: payload[f.start:f.end]}
+
+		if f.nameEnd != -1 {
+			h.name = payload[f.start:f.nameEnd]
+			h.value = unfold(payload[f.valueStart:f.valueEnd])
+		}
+
+		m.headers = append(m.headers, h)
+	}
+
+	m.tail = payload[limit:]
+
+	return m
+}
+
+// Method returns HTTP method, or nil if first line malformed
+func (m *Message) Method() []byte {
+	if m.second == nil {
+		return nil
+	}
+
+	return m.first
+}
+
+// Path returns request path, or nil if first line malformed
+func (m *Message) Path() []byte {
+	return m.second
+}
+
+// Status returns response status, it is in the same position as request path
+func (m *Message) Status() []byte {
+	return m.second
+}
+
+// Proto returns protocol version of response, e.g. `HTTP/1.1`
+func (m *Message) Proto() []byte {
+	return m.Method()
+}
+
+// absoluteHostPos returns position of host in absolute-form request target, like `http://example.com/a`, or -1
+func absoluteHostPos(path []byte) int {
+	if i := bytes.Index(path, []byte("://")); i != -1 && bytes.IndexByte(path[:i], '/') == -1 {
+		return i + 3
+	}
+
+	return -1
+}
+
+// Host returns request host: from absolute-form request target, used by HTTP/1.0 and proxy requests,
+// or from Host header. Port included if present.
+func (m *Message) Host() []byte {
+	if start := absoluteHostPos(m.second); start != -1 {
+		host := m.second[start:]
+		if end := bytes.IndexAny(host, "/?"); end != -1 {
+			host = host[:end]
+		}
+
+		return host
+	}
+
+	return m.Header([]byte("Host"))
+}
+
+// URL returns request host followed by path and query, without scheme: `example.com/a?b=1`
+func (m *Message) URL() []byte {
+	if start := absoluteHostPos(m.second); start != -1 {
+		return m.second[start:]
+	}
+
+	host := m.Header([]byte("Host"))
+	if len(host) == 0 {
+		return m.second
+	}
+
+	url := make([]byte, 0, len(host)+len(m.second))
+	url = append(url, host...)
+
+	return append(url, m.second...)
+}
+
+// SetPath sets new request path. Ignored if first line malformed.
+func (m *Message) SetPath(path []byte) {
+	if m.second == nil {
+		return
+	}
+
+	m.second = path
+	m.line = nil
+	m.modified = true
+}
+
+// PathParam returns URL query attribute by given name, if no found: valueStart will be -1
+func (m *Message) PathParam(name []byte) (value []byte, valueStart, valueEnd int) {
+	return pathParam(m.second, name)
+}
+
+// SetPathParam updates path Query attribute, if query param not found, it will append new
+func (m *Message) SetPathParam(name, value []byte) {
+	if m.second == nil {
+		return
+	}
+
+	m.SetPath(setPathParam(m.second, name, value))
+}
+
+func (m *Message) findHeader(name []byte, from int) int {
+	for i := from; i < len(m.headers); i++ {
+		if m.headers[i].name != nil && bytes.EqualFold(m.headers[i].name, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Header returns value of the first header with given name, if header not found, value will be blank.
+// Name matched exactly, case-insensitive.
+func (m *Message) Header(name []byte) []byte {
+	if i := m.findHeader(name, 0); i != -1 {
+		return m.headers[i].value
+	}
+
+	return nil
+}
+
+// Headers returns values of all headers with given name, e.g. `Set-Cookie`
+func (m *Message) Headers(name []byte) (values [][]byte) {
+	for i := m.findHeader(name, 0); i != -1; i = m.findHeader(name, i+1) {
+		values = append(values, m.headers[i].value)
+	}
+
+	return
+}
+
+// SetHeader sets value of the first header with given name. If header not found it creates new one.
+func (m *Message) SetHeader(name, value []byte) {
+	if i := m.findHeader(name, 0); i != -1 {
+		m.headers[i].value = value
+		m.headers[i].raw = nil
+		m.modified = true
+		return
+	}
+
+	m.AddHeader(name, value)
+}
+
+// UpdateHeaders replaces value of every header with given name by result of update.
+// Headers with unchanged values keep their original formatting.
+func (m *Message) UpdateHeaders(name []byte, update func(value []byte) []byte) {
+	for i := m.findHeader(name, 0); i != -1; i = m.findHeader(name, i+1) {
+		if value := update(m.headers[i].value); !bytes.Equal(value, m.headers[i].value) {
+			m.headers[i].value = value
+			m.headers[i].raw = nil
+			m.modified = true
+		}
+	}
+}
+
+// AddHeader adds new header to the start of headers section. Ignored if first line not terminated.
+func (m *Message) AddHeader(name, value []byte) {
+	if m.headersStart == -1 {
+		return
+	}
+
+	m.headers = append([]messageHeader{{name: name, value: value}}, m.headers...)
+	m.modified = true
+}
+
+// DeleteHeader removes all headers with given name
+func (m *Message) DeleteHeader(name []byte) {
+	headers := m.headers[:0]
+
+	for _, h := range m.headers {
+		if h.name != nil && bytes.EqualFold(h.name, name) {
+			m.modified = true
+			continue
+		}
+
+		headers = append(headers, h)
+	}
+
+	m.headers = headers
+}
+
+// RenameHeader changes name of all headers with given name
+func (m *Message) RenameHeader(name, newName []byte) {
+	for i := m.findHeader(name, 0); i != -1; i = m.findHeader(name, i+1) {
+		m.headers[i].name = newName
+		m.headers[i].raw = nil
+		m.modified = true
+	}
+}
+
+// Body returns request/response body.
+// If payload is incomplete, and do not contain end of headers, body will be blank.
+func (m *Message) Body() []byte {
+	if m.bodySet {
+		return m.body
+	}
+
+	if len(m.tail) < 2 {
+		return nil
+	}
+
+	return m.tail[2:]
+}
+
+// SetBody replaces body, and updates Content-Length header if it present.
+// Ignored if payload do not contain end of headers.
+func (m *Message) SetBody(body []byte) {
+	if len(m.tail) < 2 {
+		return
+	}
+
+	m.body, m.bodySet = body, true
+	m.modified = true
+
+	if len(m.Header([]byte("Content-Length"))) > 0 {
+		m.SetHeader([]byte("Content-Length"), []byte(strconv.Itoa(len(body))))
+	}
+}
+
+// Modified tells if any part of message was changed
+func (m *Message) Modified() bool {
+	return m.modified
+}
+
+// Bytes returns payload with all modifications applied.
+// If message was not modified, original payload returned.
+func (m *Message) Bytes() []byte {
+	if !m.modified {
+		return m.payload
+	}
+
+	size := len(m.first) + len(m.second) + len(m.third) + 4 + len(m.tail) + len(m.body)
+	for _, h := range m.headers {
+		size += len(h.name) + len(h.value) + len(h.raw) + 4
+	}
+
+	out := make([]byte, 0, size)
+
+	if m.line != nil {
+		out = append(out, m.line...)
+	} else {
+		out = append(out, m.first...)
+		out = append(out, ' ')
+		out = append(out, m.second...)
+
+		if m.third != nil {
+			out = append(out, ' ')
+			out = append(out, m.third...)
+		}
+
+		if m.headersStart != -1 {
+			out = append(out, CLRF...)
+		}
+	}
+
+	for _, h := range m.headers {
+		if h.raw != nil {
+			out = append(out, h.raw...)
+			continue
+		}
+
+		out = append(out, h.name...)
+		out = append(out, HeaderDelim...)
+		out = append(out, h.value...)
+		out = append(out, CLRF...)
+	}
+
+	if m.bodySet {
+		out = append(out, CLRF...)
+		return append(out, m.body...)
+	}
+
+	return append(out, m.tail...)
+}
@@ -0,0 +1,150 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	payload := []byte("POST /post?a=1 HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\nX-Folded: a\r\n b\r\nSet-Cookie: a=1\r\nset-cookie: b=2\r\n\r\na=1&b=2")
+	msg := NewMessage(payload)
+
+	if string(msg.Method()) != "POST" || string(msg.Path()) != "/post?a=1" {
+		t.Errorf("Wrong first line: %q %q", msg.Method(), msg.Path())
+	}
+
+	if string(msg.Header([]byte("host"))) != "www.w3.org" || string(msg.Header([]byte("X-Folded"))) != "a b" || msg.Header([]byte("Hos")) != nil {
+		t.Error("Wrong headers")
+	}
+
+	if values := msg.Headers([]byte("Set-Cookie")); len(values) != 2 || string(values[1]) != "b=2" {
+		t.Errorf("Wrong header values: %q", values)
+	}
+
+	if value, _, _ := msg.PathParam([]byte("a")); string(value) != "1" {
+		t.Error("Wrong param", string(value))
+	}
+
+	if string(msg.Body()) != "a=1&b=2" {
+		t.Error("Wrong body", string(msg.Body()))
+	}
+
+	if got := msg.Bytes(); &got[0] != &payload[0] || msg.Modified() {
+		t.Error("Should return original payload if not modified")
+	}
+}
+
+func TestMessageModify(t *testing.T) {
+	payload := []byte("POST /post?a=1 HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\nX-Folded: a\r\n b\r\nCookie: c=1\r\n\r\na=1&b=2")
+
+	msg := NewMessage(payload)
+	msg.SetHeader([]byte("host"), []byte("localhost"))
+	msg.SetHeader([]byte("User-Agent"), []byte("Gor"))
+	msg.DeleteHeader([]byte("Cookie"))
+	msg.RenameHeader([]byte("x-folded"), []byte("X-Unfolded"))
+	msg.SetPathParam([]byte("b"), []byte("2"))
+	msg.SetBody([]byte("hello"))
+
+	expected := SetBody(RenameHeader(DeleteHeader(SetHeader(SetHeader(SetPathParam(payload, []byte("b"), []byte("2")), []byte("Host"), []byte("localhost")), []byte("User-Agent"), []byte("Gor")), []byte("Cookie")), []byte("X-Folded"), []byte("X-Unfolded")), []byte("hello"))
+	expected = bytes.Replace(expected, []byte("a\r\n b"), []byte("a b"), 1)
+
+	if got := msg.Bytes(); !bytes.Equal(got, expected) {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, got)
+	}
+
+	if !bytes.HasPrefix(payload, []byte("POST /post?a=1 HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org")) {
+		t.Error("Original payload should not be modified")
+	}
+}
+
+func TestMessageUpdateHeaders(t *testing.T) {
+	payload := []byte("GET / HTTP/1.1\r\nX-A: 1\r\nx-a:  2\r\nX-B: 1\r\n\r\n")
+
+	msg := NewMessage(payload)
+	msg.UpdateHeaders([]byte("X-A"), func(value []byte) []byte {
+		if string(value) == "2" {
+			return []byte("3")
+		}
+		return value
+	})
+
+	if got := string(msg.Bytes()); got != "GET / HTTP/1.1\r\nX-A: 1\r\nx-a: 3\r\nX-B: 1\r\n\r\n" {
+		t.Errorf("Every header with given name should be updated: %q", got)
+	}
+
+	msg = NewMessage(payload)
+	msg.UpdateHeaders([]byte("X-A"), func(value []byte) []byte { return value })
+
+	if msg.Modified() {
+		t.Error("Message should not be modified if values unchanged")
+	}
+}
+
+func TestMessageIncomplete(t *testing.T) {
+	msg := NewMessage([]byte("GET /a HTTP/1.1\r\nHost: a\r\nAccept: *"))
+	msg.SetPath([]byte("/b"))
+	msg.SetHeader([]byte("Host"), []byte("b"))
+	msg.SetBody([]byte("body"))
+
+	if got := string(msg.Bytes()); got != "GET /b HTTP/1.1\r\nHost: b\r\nAccept: *" {
+		t.Errorf("Wrong payload: %q", got)
+	}
+
+	msg = NewMessage([]byte("GET /a"))
+	msg.SetPath([]byte("/b"))
+	msg.AddHeader([]byte("Host"), []byte("b"))
+
+	if got := string(msg.Bytes()); got != "GET /b" {
+		t.Errorf("Wrong payload: %q", got)
+	}
+
+	for _, payload := range []string{"", "GET", "GET\r\n\r\n", "\r\n"} {
+		msg = NewMessage([]byte(payload))
+		msg.SetPath([]byte("/"))
+		msg.SetPathParam([]byte("a"), []byte("b"))
+
+		if msg.Path() != nil || msg.Method() != nil || msg.Modified() {
+			t.Errorf("%q: should ignore malformed first line", payload)
+		}
+	}
+}
+
+func TestMessageResponse(t *testing.T) {
+	msg := NewMessage([]byte("HTTP/1.1 204\r\nX-A: b\r\n\r\n"))
+
+	if string(msg.Status()) != "204" || string(msg.Proto()) != "HTTP/1.1" || len(msg.Body()) != 0 {
+		t.Errorf("Wrong status line: %q %q", msg.Status(), msg.Proto())
+	}
+}
+
+func BenchmarkMessage(b *testing.B) {
+	payload := []byte("POST /post?a=1 HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\nUser-Agent: Gor\r\nAccept: */*\r\nCookie: a=1\r\n\r\na=1&b=2")
+
+	for i := 0; i < b.N; i++ {
+		msg := NewMessage(payload)
+		msg.Header([]byte("Cookie"))
+		msg.SetHeader([]byte("Host"), []byte("localhost"))
+		msg.SetPathParam([]byte("b"), []byte("2"))
+		msg.Bytes()
+	}
+}
+
+func TestMessageHostURL(t *testing.T) {
+	cases := []struct {
+		payload, host, url string
+	}{
+		{"GET /a?b=1 HTTP/1.1\r\nHost: example.com:8080\r\n\r\n", "example.com:8080", "example.com:8080/a?b=1"},
+		{"GET http://example.com/a?b=1 HTTP/1.0\r\nHost: other.com\r\n\r\n", "example.com", "example.com/a?b=1"},
+		{"GET https://example.com?b=1 HTTP/1.1\r\n\r\n", "example.com", "example.com?b=1"},
+		{"GET /a?url=http://example.com HTTP/1.1\r\n\r\n", "", "/a?url=http://example.com"},
+		{"GET /a HTTP/1.1\r\n\r\n", "", "/a"},
+	}
+
+	for _, c := range cases {
+		msg := NewMessage([]byte(c.payload))
+
+		if string(msg.Host()) != c.host || string(msg.URL()) != c.url {
+			t.Errorf("%q: wrong host or url: %q %q", c.payload, msg.Host(), msg.URL())
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package proto
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrMalformedMultipart returned if multipart body is incomplete or does not contain boundary
+var ErrMalformedMultipart = errors.New("proto: malformed multipart body")
+
+// Content of file placeholders, repeated to match original file size
+var multipartPlaceholder = []byte("gor-placeholder\n")
+
+// MultipartPart holds part of `multipart/form-data` body. Slices reference original body until modified.
+type MultipartPart struct {
+	// Part headers, without trailing empty line
+	Header []byte
+	Body   []byte
+}
+
+// Multipart is parsed `multipart/form-data` body, see RFC 7578
+type Multipart struct {
+	Boundary []byte
+	Parts    []MultipartPart
+
+	// Data before first and after last boundary, usually empty
+	preamble []byte
+	epilogue []byte
+}
+
+// headerParam returns parameter of header value, like `boundary` of `Content-Type: multipart/form-data; boundary=xyz`.
+// Quoted values are unquoted. Returns nil if parameter not found.
+func headerParam(value, name []byte) []byte {
+	params := bytes.Split(value, []byte(";"))
+
+	for _, p := range params[1:] {
+		kv := bytes.SplitN(p, []byte("="), 2)
+		if len(kv) != 2 || !bytes.EqualFold(bytes.TrimSpace(kv[0]), name) {
+			continue
+		}
+
+		v := bytes.TrimSpace(kv[1])
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = bytes.Replace(v[1:len(v)-1], []byte(`\"`), []byte(`"`), -1)
+		}
+
+		return v
+	}
+
+	return nil
+}
+
+// MultipartBoundary returns boundary of `multipart/*` payload body, or nil if payload is not multipart
+func MultipartBoundary(payload []byte) []byte {
+	return multipartBoundary(Header(payload, []byte("Content-Type")))
+}
+
+// MultipartBoundary returns boundary of `multipart/*` body, or nil if message is not multipart
+func (m *Message) MultipartBoundary() []byte {
+	return multipartBoundary(m.Header([]byte("Content-Type")))
+}
+
+func multipartBoundary(contentType []byte) []byte {
+	if !bytes.HasPrefix(bytes.ToLower(contentType), []byte("multipart/")) {
+		return nil
+	}
+
+	return headerParam(contentType, []byte("boundary"))
+}
+
+// ParseMultipart splits multipart body into parts using given boundary
+func ParseMultipart(body, boundary []byte) (*Multipart, error) {
+	if len(boundary) == 0 {
+		return nil, ErrMalformedMultipart
+	}
+
+	m := &Multipart{Boundary: boundary}
+
+	delimiter := append([]byte("--"), boundary...)
+
+	pos := bytes.Index(body, delimiter)
+	if pos == -1 || (pos > 0 && !bytes.HasSuffix(body[:pos], CLRF)) {
+		return nil, ErrMalformedMultipart
+	}
+
+	if pos > 0 {
+		// CRLF before first delimiter belongs to delimiter
+		m.preamble = body[:pos-2]
+	}
+
+	pos += len(delimiter)
+	delimiter = append([]byte("\r\n--"), boundary...)
+
+	for {
+		if bytes.HasPrefix(body[pos:], []byte("--")) {
+			m.epilogue = body[pos+2:]
+			return m, nil
+		}
+
+		// Skip optional whitespace (transport padding) after delimiter
+		lineEnd := bytes.Index(body[pos:], CLRF)
+		if lineEnd == -1 {
+			return nil, ErrMalformedMultipart
+		}
+		pos += lineEnd + 2
+
+		end := bytes.Index(body[pos:], delimiter)
+		if end == -1 {
+			return nil, ErrMalformedMultipart
+		}
+		end += pos
+
+		var part MultipartPart
+		if content := body[pos:end]; bytes.HasPrefix(content, CLRF) {
+			// Part without headers
+			part.Body = content[2:]
+		} else if headersEnd := bytes.Index(content, EmptyLine); headersEnd != -1 {
+			part.Header, part.Body = content[:headersEnd], content[headersEnd+4:]
+		} else {
+			return nil, ErrMalformedMultipart
+		}
+
+		m.Parts = append(m.Parts, part)
+		pos = end + len(delimiter)
+	}
+}
+
+// Bytes serializes multipart body
+func (m *Multipart) Bytes() []byte {
+	size := len(m.preamble) + len(m.epilogue) + len(m.Boundary) + 8
+	for _, p := range m.Parts {
+		size += len(m.Boundary) + len(p.Header) + len(p.Body) + 10
+	}
+
+	out := make([]byte, 0, size)
+
+	if len(m.preamble) > 0 {
+		out = append(append(out, m.preamble...), CLRF...)
+	}
+
+	for _, p := range m.Parts {
+		out = append(out, "--"...)
+		out = append(out, m.Boundary...)
+		out = append(out, CLRF...)
+
+		if len(p.Header) > 0 {
+			out = append(out, p.Header...)
+			out = append(out, CLRF...)
+		}
+
+		out = append(out, CLRF...)
+		out = append(out, p.Body...)
+		out = append(out, CLRF...)
+	}
+
+	out = append(out, "--"...)
+	out = append(out, m.Boundary...)
+	out = append(out, "--"...)
+
+	return append(out, m.epilogue...)
+}
+
+// partHeader returns value of part header with given name, case-insensitive
+func (p *MultipartPart) partHeader(name []byte) []byte {
+	limit := len(p.Header)
+
+	for f, ok := nextHeader(p.Header, 0, limit); ok; f, ok = nextHeader(p.Header, f.end, limit) {
+		if f.nameEnd != -1 && bytes.EqualFold(p.Header[f.start:f.nameEnd], name) {
+			return unfold(p.Header[f.valueStart:f.valueEnd])
+		}
+	}
+
+	return nil
+}
+
+// Name returns form field name from `Content-Disposition` header
+func (p *MultipartPart) Name() []byte {
+	return headerParam(p.partHeader([]byte("Content-Disposition")), []byte("name"))
+}
+
+// Filename returns file name from `Content-Disposition` header, or nil if part is not a file
+func (p *MultipartPart) Filename() []byte {
+	return headerParam(p.partHeader([]byte("Content-Disposition")), []byte("filename"))
+}
+
+// IsFile tells if part holds uploaded file
+func (p *MultipartPart) IsFile() bool {
+	return p.Filename() != nil
+}
+
+// Field returns value of the first non-file part with given name
+func (m *Multipart) Field(name []byte) (value []byte, ok bool) {
+	for i := range m.Parts {
+		if p := &m.Parts[i]; !p.IsFile() && bytes.Equal(p.Name(), name) {
+			return p.Body, true
+		}
+	}
+
+	return nil, false
+}
+
+// SetField sets value of all non-file parts with given name, if field not found new part added.
+// Tells if any part was changed or added.
+func (m *Multipart) SetField(name, value []byte) (changed bool) {
+	found := false
+
+	for i := range m.Parts {
+		if p := &m.Parts[i]; !p.IsFile() && bytes.Equal(p.Name(), name) {
+			if !bytes.Equal(p.Body, value) {
+				p.Body, changed = value, true
+			}
+			found = true
+		}
+	}
+
+	if !found {
+		header := append(append([]byte(`Content-Disposition: form-data; name="`), name...), '"')
+		m.Parts = append(m.Parts, MultipartPart{Header: header, Body: value})
+		changed = true
+	}
+
+	return
+}
+
+// ReplaceFiles replaces content of file parts, which are at least minSize bytes, with placeholder of the same size.
+// Returns number of replaced files.
+func (m *Multipart) ReplaceFiles(minSize int) (replaced int) {
+	for i := range m.Parts {
+		p := &m.Parts[i]
+		if len(p.Body) == 0 || len(p.Body) < minSize || !p.IsFile() {
+			continue
+		}
+
+		placeholder := bytes.Repeat(multipartPlaceholder, len(p.Body)/len(multipartPlaceholder)+1)
+		p.Body = placeholder[:len(p.Body)]
+		replaced++
+	}
+
+	return
+}
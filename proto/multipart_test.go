@@ -0,0 +1,126 @@
+package proto
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+var multipartBody = "--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+	"Hello\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"file\"; filename=\"a \\\"b\\\".png\"\r\n" +
+	"Content-Type: image/png\r\n\r\n" +
+	"\x89PNG\r\n\x1a\n--xy binary\r\n" +
+	"--xyz--\r\n"
+
+func TestParseMultipart(t *testing.T) {
+	payload := []byte("POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=\"xyz\"\r\nContent-Length: " + strconv.Itoa(len(multipartBody)) + "\r\n\r\n" + multipartBody)
+
+	boundary := MultipartBoundary(payload)
+	if string(boundary) != "xyz" {
+		t.Fatalf("Wrong boundary: %q", boundary)
+	}
+
+	m, err := ParseMultipart(Body(payload), boundary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(m.Parts))
+	}
+
+	if p := m.Parts[0]; string(p.Name()) != "title" || p.IsFile() || string(p.Body) != "Hello" {
+		t.Errorf("Wrong field part: %q %q", p.Name(), p.Body)
+	}
+
+	if p := m.Parts[1]; string(p.Name()) != "file" || string(p.Filename()) != `a "b".png` || string(p.Body) != "\x89PNG\r\n\x1a\n--xy binary" {
+		t.Errorf("Wrong file part: %q %q %q", p.Name(), p.Filename(), p.Body)
+	}
+
+	if !bytes.Equal(m.Bytes(), Body(payload)) {
+		t.Errorf("Serialized body should match original: %q", m.Bytes())
+	}
+
+	if value, ok := m.Field([]byte("title")); !ok || string(value) != "Hello" {
+		t.Error("Wrong field value", string(value))
+	}
+
+	if _, ok := m.Field([]byte("file")); ok {
+		t.Error("Files should not be returned as fields")
+	}
+}
+
+func TestMultipartModify(t *testing.T) {
+	m, _ := ParseMultipart([]byte(multipartBody), []byte("xyz"))
+
+	if m.SetField([]byte("title"), []byte("Hello")) {
+		t.Error("Setting the same value should not change field")
+	}
+
+	if !m.SetField([]byte("title"), []byte("World")) || !m.SetField([]byte("user_id"), []byte("1")) {
+		t.Error("Field should be changed or added")
+	}
+
+	if n := m.ReplaceFiles(100); n != 0 {
+		t.Error("Small files should be kept")
+	}
+
+	if n := m.ReplaceFiles(1); n != 1 {
+		t.Error("File should be replaced")
+	}
+
+	out := m.Bytes()
+
+	if len(out) != len(multipartBody)-len("Hello")+len("World")+len("--xyz\r\nContent-Disposition: form-data; name=\"user_id\"\r\n\r\n1\r\n") {
+		t.Errorf("File placeholder should have same size: %q", out)
+	}
+
+	parsed, err := ParseMultipart(out, []byte("xyz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _ := parsed.Field([]byte("title")); string(value) != "World" {
+		t.Error("Field should be updated", string(value))
+	}
+
+	if value, _ := parsed.Field([]byte("user_id")); string(value) != "1" {
+		t.Error("Field should be added", string(value))
+	}
+
+	if file := parsed.Parts[1].Body; len(file) != len("\x89PNG\r\n\x1a\n--xy binary") || bytes.Contains(file, []byte("PNG")) {
+		t.Errorf("Wrong placeholder: %q", file)
+	}
+}
+
+func TestParseMultipartMalformed(t *testing.T) {
+	cases := []string{
+		"",
+		"no boundary",
+		"--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nincomplete",
+		"--xyz\r\nno headers end\r\n--xyz--",
+		"prefix--xyz\r\n\r\na\r\n--xyz--",
+	}
+
+	for _, c := range cases {
+		if _, err := ParseMultipart([]byte(c), []byte("xyz")); err != ErrMalformedMultipart {
+			t.Errorf("%q: expected error, got %v", c, err)
+		}
+	}
+
+	m, err := ParseMultipart([]byte("preamble\r\n--xyz\r\n\r\nno headers\r\n--xyz--"), []byte("xyz"))
+	if err != nil || len(m.Parts) != 1 || string(m.Parts[0].Body) != "no headers" {
+		t.Fatal("Should parse part without headers", err)
+	}
+
+	if string(m.Bytes()) != "preamble\r\n--xyz\r\n\r\nno headers\r\n--xyz--" {
+		t.Errorf("Wrong serialization: %q", m.Bytes())
+	}
+
+	if MultipartBoundary([]byte("POST / HTTP/1.1\r\nContent-Type: application/json; boundary=a\r\n\r\n")) != nil {
+		t.Error("Should return boundary only for multipart")
+	}
+}
@@ -19,6 +19,7 @@ package proto
 import (
 	"bytes"
 	"github.com/buger/gor/byteutils"
+	"strconv"
 )
 
 // In HTTP newline defined by 2 bytes (for both windows and *nix support)
@@ -41,31 +42,157 @@ func MIMEHeadersStartPos(payload []byte) int {
 	return bytes.Index(payload, CLRF) + 2 // Find first line end
 }
 
+// headerField holds positions of header field inside payload
+type headerField struct {
+	start      int
+	nameEnd    int
+	valueStart int
+	valueEnd   int
+	// Position after line end, including continuation lines
+	end int
+}
+
+// headersLimit returns end of headers section: position after CRLF of the last header.
+// If payload is incomplete, headers are parsed till the end of payload.
+func headersLimit(payload []byte) int {
+	if end := MIMEHeadersEndPos(payload); end != -1 {
+		return end + 2
+	}
+
+	return len(payload)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// nextHeader parses header field which starts at pos.
+// Supports obs-fold: lines starting with space or tab continue value of previous header.
+// Returns false if there are no more fields. Malformed lines (without colon) returned with nameEnd -1.
+func nextHeader(payload []byte, pos, limit int) (f headerField, ok bool) {
+	if pos < 0 || pos >= limit || limit > len(payload) {
+		return f, false
+	}
+
+	f.start = pos
+
+	// Find line end, including continuation lines
+	for f.end = pos; f.end < limit; {
+		lineEnd := bytes.IndexByte(payload[f.end:limit], '\n')
+		if lineEnd == -1 {
+			f.end = limit
+			break
+		}
+
+		f.end += lineEnd + 1
+		if f.end >= limit || !isSpace(payload[f.end]) {
+			break
+		}
+	}
+
+	f.valueEnd = f.end
+	for f.valueEnd > f.start && (payload[f.valueEnd-1] == '\n' || payload[f.valueEnd-1] == '\r' || isSpace(payload[f.valueEnd-1])) {
+		f.valueEnd--
+	}
+
+	colon := bytes.IndexByte(payload[f.start:f.valueEnd], ':')
+	if colon == -1 {
+		f.nameEnd, f.valueStart, f.valueEnd = -1, -1, -1
+		return f, true
+	}
+
+	f.nameEnd = f.start + colon
+	for f.nameEnd > f.start && isSpace(payload[f.nameEnd-1]) {
+		f.nameEnd--
+	}
+
+	f.valueStart = f.start + colon + 1
+	for f.valueStart < f.valueEnd && isSpace(payload[f.valueStart]) {
+		f.valueStart++
+	}
+
+	return f, true
+}
+
+// findHeader finds first header with given name starting from given position (or start of headers if 0).
+// Name matched exactly, case-insensitive. Returns field with start -1 if not found.
+func findHeader(payload, name []byte, from int) headerField {
+	limit := headersLimit(payload)
+
+	pos := bytes.Index(payload, CLRF)
+	if pos == -1 {
+		return headerField{start: -1}
+	}
+
+	if pos += 2; from > pos {
+		pos = from
+	}
+
+	for f, ok := nextHeader(payload, pos, limit); ok; f, ok = nextHeader(payload, f.end, limit) {
+		if f.nameEnd != -1 && bytes.EqualFold(payload[f.start:f.nameEnd], name) {
+			return f
+		}
+	}
+
+	return headerField{start: -1}
+}
+
 // header return value and positions of header/value start/end.
 // If not found, value will be blank, and headerStart will be -1
-// Do not support multi-line headers.
+// Value of multi-line (obs-fold) headers contains line breaks.
 func header(payload []byte, name []byte) (value []byte, headerStart, valueStart, headerEnd int) {
-	headerStart = bytes.Index(payload, name)
+	f := findHeader(payload, name, 0)
 
-	if headerStart == -1 {
-		return
+	if f.start == -1 {
+		return nil, -1, -1, -1
 	}
 
-	valueStart = headerStart + len(name) + 1 // Skip ":" after header name
-	if payload[valueStart] == ' ' {          // Ignore empty space after ':'
-		valueStart++
+	return payload[f.valueStart:f.valueEnd], f.start, f.valueStart, f.valueEnd
+}
+
+// unfold replaces line breaks of multi-line (obs-fold) header value with single space
+func unfold(value []byte) []byte {
+	if bytes.IndexByte(value, '\n') == -1 {
+		return value
 	}
-	headerEnd = valueStart + bytes.IndexByte(payload[valueStart:], '\r')
-	value = payload[valueStart:headerEnd]
 
-	return
+	out := make([]byte, 0, len(value))
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\r' && value[i] != '\n' {
+			out = append(out, value[i])
+			continue
+		}
+
+		for len(out) > 0 && isSpace(out[len(out)-1]) {
+			out = out[:len(out)-1]
+		}
+
+		for i+1 < len(value) && (value[i+1] == '\r' || value[i+1] == '\n' || isSpace(value[i+1])) {
+			i++
+		}
+
+		out = append(out, ' ')
+	}
+
+	return out
 }
 
-// Header returns header value, if header not found, value will be blank
+// Header returns value of the first header with given name, if header not found, value will be blank
+// Name matched exactly, case-insensitive.
 func Header(payload, name []byte) []byte {
 	val, _, _, _ := header(payload, name)
 
-	return val
+	return unfold(val)
+}
+
+// Headers returns values of all headers with given name, e.g. `Set-Cookie`
+func Headers(payload, name []byte) (values [][]byte) {
+	for f := findHeader(payload, name, 0); f.start != -1; f = findHeader(payload, name, f.end) {
+		values = append(values, unfold(payload[f.valueStart:f.valueEnd]))
+	}
+
+	return
 }
 
 // SetHeader sets header value. If header not found it creates new one.
@@ -84,6 +211,10 @@ func SetHeader(payload, name, value []byte) []byte {
 // AddHeader takes http payload and appends new header to the start of headers section
 // Returns modified request payload
 func AddHeader(payload, name, value []byte) []byte {
+	if bytes.Index(payload, CLRF) == -1 {
+		return payload
+	}
+
 	header := make([]byte, len(name)+2+len(value)+2)
 	copy(header[0:], name)
 	copy(header[len(name):], HeaderDelim)
@@ -95,32 +226,108 @@ func AddHeader(payload, name, value []byte) []byte {
 	return byteutils.Insert(payload, mimeStart, header)
 }
 
+// DeleteHeader removes all headers with given name, name matched case-insensitive
+// Returns modified payload
+func DeleteHeader(payload, name []byte) []byte {
+	for f := findHeader(payload, name, 0); f.start != -1; f = findHeader(payload, name, f.start) {
+		payload = byteutils.Cut(payload, f.start, f.end)
+	}
+
+	return payload
+}
+
+// RenameHeader changes name of all headers with given name, name matched case-insensitive
+// Returns modified payload
+func RenameHeader(payload, name, newName []byte) []byte {
+	for f := findHeader(payload, name, 0); f.start != -1; f = findHeader(payload, name, f.end+len(newName)-(f.nameEnd-f.start)) {
+		payload = byteutils.Replace(payload, f.start, f.nameEnd, newName)
+	}
+
+	return payload
+}
+
 // Body returns request/response body
+// If payload is incomplete, and do not contain end of headers, body will be blank
 func Body(payload []byte) []byte {
+	end := MIMEHeadersEndPos(payload)
+	if end == -1 {
+		return nil
+	}
+
 	// 4 -> len(EMPTY_LINE)
-	return payload[MIMEHeadersEndPos(payload)+4:]
+	return payload[end+4:]
+}
+
+// SetBody replaces request/response body, and updates Content-Length header if it present
+// Returns modified payload
+func SetBody(payload, body []byte) []byte {
+	headersEnd := MIMEHeadersEndPos(payload)
+	if headersEnd == -1 {
+		return payload
+	}
+
+	data := make([]byte, 0, headersEnd+4+len(body))
+	data = append(data, payload[:headersEnd+4]...)
+	data = append(data, body...)
+
+	if len(Header(data, []byte("Content-Length"))) > 0 {
+		data = SetHeader(data, []byte("Content-Length"), []byte(strconv.Itoa(len(body))))
+	}
+
+	return data
+}
+
+// pathPos returns position of the second element of the first line, or -1 if not found
+func pathPos(payload []byte) (start, end int) {
+	lineEnd := bytes.IndexByte(payload, '\n')
+	if lineEnd == -1 {
+		lineEnd = len(payload)
+	}
+
+	line := bytes.TrimRight(payload[:lineEnd], "\r")
+
+	start = bytes.IndexByte(line, ' ') + 1
+	if start == 0 {
+		return -1, -1
+	}
+
+	if end = bytes.IndexByte(line[start:], ' '); end == -1 {
+		// Status line without reason
+		end = len(line)
+	} else {
+		end += start
+	}
+
+	return start, end
 }
 
 // Path takes payload and retuns request path: Split(firstLine, ' ')[1]
+// Returns nil if first line malformed
 func Path(payload []byte) []byte {
-	start := bytes.IndexByte(payload, ' ') + 1
-	end := bytes.IndexByte(payload[start:], ' ')
+	start, end := pathPos(payload)
+	if start == -1 {
+		return nil
+	}
 
-	return payload[start : start+end]
+	return payload[start:end]
 }
 
 // SetPath takes payload, sets new path and returns modified payload
 func SetPath(payload, path []byte) []byte {
-	start := bytes.IndexByte(payload, ' ') + 1
-	end := bytes.IndexByte(payload[start:], ' ')
+	start, end := pathPos(payload)
+	if start == -1 {
+		return payload
+	}
 
-	return byteutils.Replace(payload, start, start+end, path)
+	return byteutils.Replace(payload, start, end, path)
 }
 
 // PathParam returns URL query attribute by given name, if no found: valueStart will be -1
 func PathParam(payload, name []byte) (value []byte, valueStart, valueEnd int) {
-	path := Path(payload)
+	return pathParam(Path(payload), name)
+}
 
+func pathParam(path, name []byte) (value []byte, valueStart, valueEnd int) {
 	if paramStart := bytes.Index(path, append(name, '=')); paramStart != -1 {
 		valueStart := paramStart + len(name) + 1
 		paramEnd := bytes.IndexByte(path[valueStart:], '&')
@@ -142,15 +349,18 @@ func PathParam(payload, name []byte) (value []byte, valueStart, valueEnd int) {
 // If query param not found, it will append new
 // Returns modified payload
 func SetPathParam(payload, name, value []byte) []byte {
-	path := Path(payload)
-	_, vs, ve := PathParam(payload, name)
+	return SetPath(payload, setPathParam(Path(payload), name, value))
+}
 
-	if vs != -1 { // If param found, replace its value and set new Path
+// setPathParam returns new path with updated or appended query param
+func setPathParam(path, name, value []byte) []byte {
+	_, vs, ve := pathParam(path, name)
+
+	if vs != -1 { // If param found, replace its value and return new Path
 		newPath := make([]byte, len(path))
 		copy(newPath, path)
-		newPath = byteutils.Replace(newPath, vs, ve, value)
 
-		return SetPath(payload, newPath)
+		return byteutils.Replace(newPath, vs, ve, value)
 	}
 
 	// if param not found append to end of url
@@ -173,7 +383,7 @@ func SetPathParam(payload, name, value []byte) []byte {
 	copy(newPath, path)
 	copy(newPath[len(path):], newParam)
 
-	return SetPath(payload, newPath)
+	return newPath
 }
 
 // SetHost updates Host header for HTTP/1.1 or updates host in path for HTTP/1.0 or Proxy requests
@@ -196,9 +406,16 @@ func SetHost(payload, url, host []byte) []byte {
 	return SetHeader(payload, []byte("Host"), host)
 }
 
-// Method returns HTTP method
+// Method returns HTTP method, or nil if first line malformed
 func Method(payload []byte) []byte {
 	end := bytes.IndexByte(payload, ' ')
+	if end == -1 {
+		return nil
+	}
+
+	if lineEnd := bytes.IndexByte(payload[:end], '\n'); lineEnd != -1 {
+		return nil
+	}
 
 	return payload[:end]
 }
@@ -209,21 +426,17 @@ func Status(payload []byte) []byte {
 	return Path(payload)
 }
 
-var httpMethods []string = []string{
-	"GET ", "OPTI", "HEAD", "POST", "PUT ", "DELE", "TRAC", "CONN",
-}
-
+// IsHTTPPayload checks if payload starts with recognized method, see AddMethod
 func IsHTTPPayload(payload []byte) bool {
-	if len(payload) < 4 {
-		return false
+	limit := maxMethodLength + 1
+	if anyMethod || limit > len(payload) {
+		limit = len(payload)
 	}
 
-	method := string(payload[0:4])
-
-	for _, m := range httpMethods {
-		if method == m {
-			return true
-		}
+	end := bytes.IndexByte(payload[:limit], ' ')
+	if end == -1 {
+		return false
 	}
-	return false
+
+	return IsMethod(payload[:end])
 }
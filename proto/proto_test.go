@@ -26,6 +26,62 @@ func TestHeader(t *testing.T) {
 	}
 }
 
+func TestHeaderExactMatch(t *testing.T) {
+	payload := []byte("GET /Host: a HTTP/1.1\r\nX-Forwarded-Host: proxy\r\ncontent-length: 4\r\nHost: www.w3.org\r\n\r\nHost")
+
+	if val := Header(payload, []byte("Host")); !bytes.Equal(val, []byte("www.w3.org")) {
+		t.Errorf("Should match exact header name, got %q", val)
+	}
+
+	if val := Header(payload, []byte("Content-Length")); !bytes.Equal(val, []byte("4")) {
+		t.Errorf("Should match header name case-insensitive, got %q", val)
+	}
+
+	if val := Header([]byte("GET / HTTP/1.1\r\n\r\nHost: body"), []byte("Host")); len(val) != 0 {
+		t.Errorf("Should not match headers inside body, got %q", val)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	payload := []byte("HTTP/1.1 200 OK\r\nSet-Cookie: a=1\r\nX-Long: first\r\n  second \r\n\tthird\r\nset-cookie: b=2 \r\n\r\n")
+
+	values := Headers(payload, []byte("Set-Cookie"))
+	if len(values) != 2 || !bytes.Equal(values[0], []byte("a=1")) || !bytes.Equal(values[1], []byte("b=2")) {
+		t.Errorf("Should return all header values: %q", values)
+	}
+
+	if val := Header(payload, []byte("X-Long")); !bytes.Equal(val, []byte("first second third")) {
+		t.Errorf("Should unfold multi-line header, got %q", val)
+	}
+
+	payload = SetHeader(payload, []byte("X-Long"), []byte("short"))
+	if !bytes.Equal(payload, []byte("HTTP/1.1 200 OK\r\nSet-Cookie: a=1\r\nX-Long: short\r\nset-cookie: b=2 \r\n\r\n")) {
+		t.Errorf("Should replace whole multi-line header: %q", payload)
+	}
+}
+
+func TestHeaderMalformed(t *testing.T) {
+	for _, payload := range []string{
+		"",
+		"GET",
+		"GET / HTTP/1.1",
+		"GET / HTTP/1.1\r\nHost",
+		"GET / HTTP/1.1\r\nHost:",
+		"GET / HTTP/1.1\r\nbroken line\r\nHost:\r\n",
+		"GET / HTTP/1.1\r\nX-A: 1\r\n",
+	} {
+		Header([]byte(payload), []byte("Host"))
+		Headers([]byte(payload), []byte("Host"))
+		DeleteHeader([]byte(payload), []byte("Host"))
+		SetHeader([]byte(payload), []byte("Host"), []byte("a"))
+		Body([]byte(payload))
+	}
+
+	if val := Header([]byte("GET / HTTP/1.1\r\nHost: www.w3.org"), []byte("Host")); !bytes.Equal(val, []byte("www.w3.org")) {
+		t.Errorf("Should parse headers of incomplete payload, got %q", val)
+	}
+}
+
 func TestMIMEHeadersEndPos(t *testing.T) {
 	head := []byte("POST /post HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org")
 	payload := []byte("POST /post HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\n\r\na=1&b=2")
@@ -67,6 +123,46 @@ func TestSetHeader(t *testing.T) {
 	}
 }
 
+func TestDeleteHeader(t *testing.T) {
+	payload := []byte("POST /post HTTP/1.1\r\nX-Forwarded-For: 1.1.1.1\r\nHost: www.w3.org\r\nx-forwarded-for: 2.2.2.2\r\n\r\nX-Forwarded-For: body")
+	payloadAfter := []byte("POST /post HTTP/1.1\r\nHost: www.w3.org\r\n\r\nX-Forwarded-For: body")
+
+	if payload = DeleteHeader(payload, []byte("X-FORWARDED-FOR")); !bytes.Equal(payload, payloadAfter) {
+		t.Error("Should remove all headers with given name", string(payload))
+	}
+
+	if payload = DeleteHeader(payload, []byte("Host")); !bytes.Equal(payload, []byte("POST /post HTTP/1.1\r\n\r\nX-Forwarded-For: body")) {
+		t.Error("Should remove last header", string(payload))
+	}
+}
+
+func TestRenameHeader(t *testing.T) {
+	payload := []byte("GET / HTTP/1.1\r\nauthorization: a\r\nX-Authorization: b\r\nAuthorization: c\r\n\r\n")
+	payloadAfter := []byte("GET / HTTP/1.1\r\nX-Original-Auth: a\r\nX-Authorization: b\r\nX-Original-Auth: c\r\n\r\n")
+
+	if payload = RenameHeader(payload, []byte("Authorization"), []byte("X-Original-Auth")); !bytes.Equal(payload, payloadAfter) {
+		t.Error("Should rename all headers with exactly matching name", string(payload))
+	}
+
+	if payload = RenameHeader(payload, []byte("x-original-auth"), []byte("X-ORIGINAL-AUTH")); !bytes.Contains(payload, []byte("\r\nX-ORIGINAL-AUTH: c\r\n")) {
+		t.Error("Should rename headers if names differ only by case", string(payload))
+	}
+}
+
+func TestSetBody(t *testing.T) {
+	payload := []byte("POST /post HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\n\r\na=1&b=2")
+	payloadAfter := []byte("POST /post HTTP/1.1\r\nContent-Length: 3\r\nHost: www.w3.org\r\n\r\nc=3")
+
+	if payload = SetBody(payload, []byte("c=3")); !bytes.Equal(payload, payloadAfter) {
+		t.Error("Should replace body and update Content-Length", string(payload))
+	}
+
+	payload = []byte("GET / HTTP/1.1\r\n\r\n")
+	if payload = SetBody(payload, []byte("a")); !bytes.Equal(payload, []byte("GET / HTTP/1.1\r\n\r\na")) {
+		t.Error("Should not add Content-Length", string(payload))
+	}
+}
+
 func TestPath(t *testing.T) {
 	var path, payload []byte
 
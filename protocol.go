@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -41,23 +43,91 @@ func payloadScanner(data []byte, atEOF bool) (advance int, token []byte, err err
 	return 0, nil, nil
 }
 
+// Keys of optional payload meta fields
+const (
+	// Address of the peer which sent payload, `ip:port`
+	metaSrc = "src"
+	// Address of the peer which received payload, `ip:port`
+	metaDst = "dst"
+	// TCP connection identifier, same for request and its response
+	metaConn = "conn"
+	// Name of the input plugin which produced payload
+	metaInput = "input"
+	// TCP sequence number of the first payload packet
+	metaSeq = "seq"
+	// Address of the output which replayed request, set for replayed responses
+	metaTarget = "target"
+)
+
+var metaValueReplacer = strings.NewReplacer(" ", "_", "\n", "_", "\r", "_")
+
+// metaField builds optional `key=value` meta field, spaces and new lines are not allowed in value
+func metaField(key, value string) string {
+	return key + "=" + metaValueReplacer.Replace(value)
+}
+
+// metaFields builds meta fields from map, sorted by key
+func metaFields(meta map[string]string) []string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]string, len(keys))
+	for i, k := range keys {
+		fields[i] = metaField(k, meta[k])
+	}
+
+	return fields
+}
+
+// payloadMetaFields parses optional `key=value` meta fields, returns nil if there are none
+func payloadMetaFields(meta [][]byte) map[string]string {
+	if len(meta) < 4 {
+		return nil
+	}
+
+	var fields map[string]string
+
+	for _, field := range meta[3:] {
+		if kv := bytes.SplitN(field, []byte{'='}, 2); len(kv) == 2 {
+			if fields == nil {
+				fields = make(map[string]string)
+			}
+			fields[string(kv[0])] = string(kv[1])
+		}
+	}
+
+	return fields
+}
+
 // Timing is request start or round-trip time, depending on payloadType
-func payloadHeader(payloadType byte, uuid []byte, timing int64) (header []byte) {
+// Optional meta fields appended to the end of header, see metaField
+func payloadHeader(payloadType byte, uuid []byte, timing int64, fields ...string) (header []byte) {
 	sTime := strconv.FormatInt(timing, 10)
 
 	//Example:
 	//  3 f45590522cd1838b4a0d5c5aab80b77929dea3b3 1231\n
+	//  1 f45590522cd1838b4a0d5c5aab80b77929dea3b3 1441461600000000000 src=10.0.0.1:51234 input=raw\n
 	// `+ 1` indicates space characters or end of line
-	header = make([]byte, 1+1+len(uuid)+1+len(sTime)+1)
-	header[0] = payloadType
-	header[1] = ' '
-	header[2+len(uuid)] = ' '
-	header[len(header)-1] = '\n'
+	size := 1 + 1 + len(uuid) + 1 + len(sTime) + 1
+	for _, f := range fields {
+		size += len(f) + 1
+	}
+
+	header = make([]byte, 0, size)
+	header = append(header, payloadType, ' ')
+	header = append(header, uuid...)
+	header = append(header, ' ')
+	header = append(header, sTime...)
 
-	copy(header[2:], uuid)
-	copy(header[3+len(uuid):], sTime)
+	for _, f := range fields {
+		header = append(header, ' ')
+		header = append(header, f...)
+	}
 
-	return header
+	return append(header, '\n')
 }
 
 func payloadBody(payload []byte) []byte {
@@ -70,6 +140,22 @@ func payloadMeta(payload []byte) [][]byte {
 	return bytes.Split(payload[:headerSize], []byte{' '})
 }
 
+// payloadMetaValue returns value of optional meta field, or nil if it not found
+// Old payloads contain only 3 fields: type, uuid and timing
+func payloadMetaValue(meta [][]byte, key string) []byte {
+	if len(meta) < 4 {
+		return nil
+	}
+
+	for _, field := range meta[3:] {
+		if len(field) > len(key) && field[len(key)] == '=' && string(field[:len(key)]) == key {
+			return field[len(key)+1:]
+		}
+	}
+
+	return nil
+}
+
 func isOriginPayload(payload []byte) bool {
 	switch payload[0] {
 	case RequestPayload, ResponsePayload:
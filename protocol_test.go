@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPayloadHeaderMetaFields(t *testing.T) {
+	header := payloadHeader(RequestPayload, []byte("abc"), 1, metaField(metaSrc, "10.0.0.1:5000"), metaField(metaInput, "Test input"))
+
+	if !bytes.Equal(header, []byte("1 abc 1 src=10.0.0.1:5000 input=Test_input\n")) {
+		t.Errorf("Wrong header: %q", header)
+	}
+
+	meta := payloadMeta(append(header, []byte("GET / HTTP/1.1\r\n\r\n")...))
+
+	if string(meta[1]) != "abc" || string(meta[2]) != "1" {
+		t.Error("Should keep positional fields:", meta)
+	}
+
+	if v := payloadMetaValue(meta, metaSrc); string(v) != "10.0.0.1:5000" {
+		t.Error("Should find meta field value:", string(v))
+	}
+
+	if v := payloadMetaValue(meta, "sr"); v != nil {
+		t.Error("Should match only whole key:", string(v))
+	}
+
+	if v := payloadMetaValue(payloadMeta([]byte("1 abc 1\nGET / HTTP/1.1\r\n\r\n")), metaSrc); v != nil {
+		t.Error("Old payloads do not have meta fields")
+	}
+}
+
+func TestPayloadMetaFieldsShortMeta(t *testing.T) {
+	if fields := payloadMetaFields(payloadMeta([]byte("1 abc\nGET / HTTP/1.1\r\n\r\n"))); fields != nil {
+		t.Error("Truncated meta should not have fields:", fields)
+	}
+
+	if fields := payloadMetaFields(payloadMeta([]byte("1 abc 1 src=a\n"))); fields["src"] != "a" {
+		t.Error("Should parse meta fields:", fields)
+	}
+}
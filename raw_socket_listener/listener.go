@@ -15,11 +15,13 @@ package rawSocket
 import (
 	"bytes"
 	"encoding/binary"
+	"hash/fnv"
 	"log"
 	"net"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -54,8 +56,10 @@ type Listener struct {
 }
 
 type request struct {
-	start time.Time
-	ack   uint32
+	start  time.Time
+	ack    uint32
+	src    string
+	connID string
 }
 
 // NewListener creates and initializes new Listener object
@@ -136,11 +140,17 @@ func (t *Listener) readRAWSocket() {
 
 	defer t.conn.Close()
 
+	// ReadFrom strips IP header, but destination address needed when listening on all interfaces,
+	// so packets read from socket directly
+	rc, e := conn.(*net.IPConn).SyscallConn()
+	if e != nil {
+		log.Fatal(e)
+	}
+
 	buf := make([]byte, 64*1024) // 64kb
 
 	for {
-		// Note: ReadFrom receive messages without IP header
-		n, addr, err := t.conn.ReadFrom(buf)
+		n, err := readIPPacket(rc, buf)
 
 		if err != nil {
 			if strings.HasSuffix(err.Error(), "closed network connection") {
@@ -151,19 +161,59 @@ func (t *Listener) readRAWSocket() {
 			}
 		}
 
-		if n > 0 {
-			if t.isValidPacket(buf[:n]) {
-				newBuf := make([]byte, n)
-				copy(newBuf, buf[:n])
+		src, dst, data := parseIPv4Header(buf[:n])
+		if len(data) < 20 {
+			continue
+		}
 
-				go func(newBuf []byte) {
-					t.packetsChan <- ParseTCPPacket(addr, newBuf)
-				}(newBuf)
-			}
+		if t.isValidPacket(data) {
+			newBuf := make([]byte, len(data))
+			copy(newBuf, data)
+
+			go func(newBuf []byte) {
+				packet := ParseTCPPacket(&net.IPAddr{IP: src}, newBuf)
+				packet.DestIP = dst
+				t.packetsChan <- packet
+			}(newBuf)
 		}
 	}
 }
 
+// readIPPacket reads raw IPv4 packet, including IP header
+func readIPPacket(rc syscall.RawConn, buf []byte) (n int, err error) {
+	var readErr error
+
+	err = rc.Read(func(fd uintptr) bool {
+		n, _, readErr = syscall.Recvfrom(int(fd), buf, 0)
+		// Not ready yet, wait for socket to become readable
+		return readErr != syscall.EAGAIN
+	})
+
+	if err == nil {
+		err = readErr
+	}
+
+	return
+}
+
+// parseIPv4Header returns source and destination addresses and payload of IPv4 packet.
+// Payload is empty if packet is malformed. Addresses are copied, so buffer can be reused.
+func parseIPv4Header(packet []byte) (src, dst net.IP, data []byte) {
+	if len(packet) < 20 {
+		return
+	}
+
+	headerLen := int(packet[0]&0x0F) * 4
+	if headerLen < 20 || len(packet) < headerLen {
+		return
+	}
+
+	src = net.IPv4(packet[12], packet[13], packet[14], packet[15])
+	dst = net.IPv4(packet[16], packet[17], packet[18], packet[19])
+
+	return src, dst, packet[headerLen:]
+}
+
 func (t *Listener) isValidPacket(buf []byte) bool {
 	// To avoid full packet parsing every time, we manually parsing values needed for packet filtering
 	// http://en.wikipedia.org/wiki/Transmission_Control_Protocol
@@ -227,9 +277,16 @@ func (t *Listener) processTCPPacket(packet *TCPPacket) {
 		message = NewTCPMessage(mID, packet.Ack, isIncoming)
 		t.messages[mID] = message
 
-		if !isIncoming && responseRequest != nil {
+		message.SrcAddr = net.JoinHostPort(addrIP(packet.Addr), strconv.Itoa(int(packet.SrcPort)))
+
+		if isIncoming {
+			message.DstAddr = net.JoinHostPort(destIP(packet, t.addr), strconv.Itoa(int(packet.DestPort)))
+			message.ConnID = connID(message.SrcAddr, message.DstAddr)
+		} else if responseRequest != nil {
 			message.RequestStart = responseRequest.start
 			message.RequestAck = responseRequest.ack
+			message.DstAddr = responseRequest.src
+			message.ConnID = responseRequest.connID
 		}
 	}
 
@@ -251,7 +308,7 @@ func (t *Listener) processTCPPacket(packet *TCPPacket) {
 		}
 
 		responseAck := packet.Seq + uint32(len(packet.Data))
-		t.respAliases[responseAck] = &request{message.Start, message.Ack}
+		t.respAliases[responseAck] = &request{message.Start, message.Ack, message.SrcAddr, message.ConnID}
 		message.ResponseAck = responseAck
 	}
 
@@ -264,6 +321,34 @@ func (t *Listener) processTCPPacket(packet *TCPPacket) {
 	}
 }
 
+// addrIP returns IP part of the packet source address
+func addrIP(addr net.Addr) string {
+	if ip, ok := addr.(*net.IPAddr); ok {
+		return ip.IP.String()
+	}
+
+	return addr.String()
+}
+
+// destIP returns destination IP of packet, or listen address if packet was read without IP header
+func destIP(packet *TCPPacket, addr string) string {
+	if packet.DestIP != nil {
+		return packet.DestIP.String()
+	}
+
+	return addr
+}
+
+// connID returns short identifier of TCP connection between given client and server
+func connID(client, server string) string {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(client))
+	hasher.Write([]byte{'-'})
+	hasher.Write([]byte(server))
+
+	return strconv.FormatUint(hasher.Sum64(), 16)
+}
+
 // Receive TCP messages from the listener channel
 func (t *Listener) Receive() *TCPMessage {
 	return <-t.messagesChan
@@ -26,6 +26,12 @@ type TCPMessage struct {
 	End          time.Time
 	IsIncoming   bool
 
+	// Addresses of the peer which sent message and which received it, in `ip:port` format
+	SrcAddr string
+	DstAddr string
+	// Identifies TCP connection: same for request and response
+	ConnID string
+
 	packets []*TCPPacket
 
 	delChan chan *TCPMessage
@@ -48,6 +54,11 @@ func (t *TCPMessage) Bytes() (output []byte) {
 	return output
 }
 
+// Seq returns TCP sequence number of the first message packet
+func (t *TCPMessage) Seq() uint32 {
+	return t.packets[0].Seq
+}
+
 // Size returns total size of message
 func (t *TCPMessage) Size() (size int) {
 	size += len(proto.Body(t.packets[0].Data))
@@ -92,15 +103,16 @@ func (t *TCPMessage) IsMultipart() bool {
 	}
 
 	payload := t.packets[0].Data
-	m := payload[:4]
 
 	if t.IsIncoming {
+		m := proto.Method(payload)
+
 		// If one GET, OPTIONS, or HEAD request
-		if bytes.Equal(m, []byte("GET ")) || bytes.Equal(m, []byte("OPTI")) || bytes.Equal(m, []byte("HEAD")) {
+		if bytes.Equal(m, []byte("GET")) || bytes.Equal(m, []byte("OPTIONS")) || bytes.Equal(m, []byte("HEAD")) {
 			return false
 		} else {
 			// Sometimes header comes after the body :(
-			if bytes.Equal(m, []byte("POST")) || bytes.Equal(m, []byte("PUT ")) || bytes.Equal(m, []byte("PATC")) {
+			if proto.IsHTTPPayload(payload) {
 				if length := proto.Header(payload, []byte("Content-Length")); len(length) > 0 {
 					l, _ := strconv.Atoi(string(length))
 
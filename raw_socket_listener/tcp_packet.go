@@ -36,6 +36,8 @@ type TCPPacket struct {
 	Data []byte
 
 	Addr net.Addr
+	// Destination address from IP header, nil if unknown
+	DestIP net.IP
 }
 
 // ParseTCPPacket takes address and tcp payload and returns parsed TCPPacket
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ResponseFilterConfig holds filters applied to responses, see --http-response-filter
+type ResponseFilterConfig struct {
+	// Evaluated against original response, request and response emitted or dropped together
+	filters HTTPFilters
+	// Evaluated against replayed responses, only replayed response dropped
+	replayedFilters HTTPFilters
+	// How long request waits for its response, requests without response after this time are dropped
+	window time.Duration
+}
+
+type responseFilterEntry struct {
+	request  []byte
+	response []byte
+	created  time.Time
+}
+
+// ResponseFilter holds requests until their original responses are seen, and then emits or drops both,
+// so traffic can be filtered by response properties, like status or latency.
+//
+// Request and response can come in any order, and from different goroutines.
+type ResponseFilter struct {
+	mu     sync.Mutex
+	config *ResponseFilterConfig
+	// Decode compressed bodies before evaluating filters
+	decodeBody bool
+
+	pending   map[string]*responseFilterEntry
+	lastSweep time.Time
+}
+
+// ResponseFilters is shared response filter, nil if disabled
+var ResponseFilters *ResponseFilter
+
+// NewResponseFilter constructor for ResponseFilter, returns nil if no filters configured
+func NewResponseFilter(config *ResponseFilterConfig, decodeBody bool) *ResponseFilter {
+	if len(config.filters) == 0 && len(config.replayedFilters) == 0 {
+		return nil
+	}
+
+	return &ResponseFilter{
+		config:     config,
+		decodeBody: decodeBody,
+		pending:    make(map[string]*responseFilterEntry),
+		lastSweep:  time.Now(),
+	}
+}
+
+// Filter takes payload of any type, and returns payloads which should be passed further.
+// Returns nothing if payload is held or dropped, and request followed by its response once response matched filters.
+// Held payloads are copied, so input buffer can be reused.
+func (f *ResponseFilter) Filter(payload []byte) [][]byte {
+	meta := payloadMeta(payload)
+	if len(meta) < 2 || len(meta[0]) == 0 {
+		return [][]byte{payload}
+	}
+
+	switch meta[0][0] {
+	case ReplayedResponsePayload:
+		if len(f.config.replayedFilters) == 0 || f.config.replayedFilters.MatchPayload(payload, f.decodeBody) {
+			return [][]byte{payload}
+		}
+
+		return nil
+	case RequestPayload, ResponsePayload:
+		if len(f.config.filters) == 0 {
+			return [][]byte{payload}
+		}
+	default:
+		return [][]byte{payload}
+	}
+
+	id := string(meta[1])
+	isRequest := isRequestPayload(payload)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sweep()
+
+	e, ok := f.pending[id]
+	if !ok {
+		e = &responseFilterEntry{created: time.Now()}
+		f.pending[id] = e
+	}
+
+	if isRequest {
+		if e.response == nil {
+			e.request = append([]byte(nil), payload...)
+			return nil
+		}
+
+		delete(f.pending, id)
+		return f.decide(payload, e.response)
+	}
+
+	if e.request == nil {
+		e.response = append([]byte(nil), payload...)
+		return nil
+	}
+
+	delete(f.pending, id)
+	return f.decide(e.request, payload)
+}
+
+// decide evaluates filters against response, and returns both payloads if matched
+func (f *ResponseFilter) decide(request, response []byte) [][]byte {
+	if !f.config.filters.MatchPayload(response, f.decodeBody) {
+		return nil
+	}
+
+	return [][]byte{request, response}
+}
+
+// sweep drops payloads which did not get their pair during window, should be called under lock
+func (f *ResponseFilter) sweep() {
+	now := time.Now()
+	if now.Sub(f.lastSweep) < f.config.window {
+		return
+	}
+	f.lastSweep = now
+
+	dropped := 0
+
+	for id, e := range f.pending {
+		if now.Sub(e.created) > f.config.window {
+			delete(f.pending, id)
+			dropped++
+		}
+	}
+
+	if dropped > 0 {
+		Debug("[RESPONSE-FILTER] Dropped", dropped, "payloads without pair after", f.config.window)
+	}
+}
+
+// Len returns number of held payloads
+func (f *ResponseFilter) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.pending)
+}
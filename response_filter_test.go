@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func newTestResponseFilter(t *testing.T, filter, replayedFilter string, window time.Duration) *ResponseFilter {
+	config := &ResponseFilterConfig{window: window}
+
+	if filter != "" {
+		if err := config.filters.Set(filter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if replayedFilter != "" {
+		if err := config.replayedFilters.Set(replayedFilter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewResponseFilter(config, false)
+}
+
+func TestResponseFilter(t *testing.T) {
+	if NewResponseFilter(&ResponseFilterConfig{}, false) != nil {
+		t.Error("Should not be initialized without filters")
+	}
+
+	f := newTestResponseFilter(t, `status >= 500 or latency > 500`, "", time.Minute)
+
+	request := []byte("1 a 1\nGET / HTTP/1.1\r\n\r\n")
+	if out := f.Filter(request); len(out) != 0 {
+		t.Error("Request should be held")
+	}
+
+	response := []byte("2 a 1000000\nHTTP/1.1 500 Internal Server Error\r\n\r\n")
+	if out := f.Filter(response); len(out) != 2 || !bytes.Equal(out[0], request) || !bytes.Equal(out[1], response) {
+		t.Errorf("Request and response should be emitted: %q", out)
+	}
+
+	// Response comes before request, request buffer reused
+	buf := []byte("2 b 600000000\nHTTP/1.1 200 OK\r\n\r\n")
+	f.Filter(buf)
+	copy(buf, "xxxxxxxxxxxxxx")
+
+	if out := f.Filter([]byte("1 b 1\nGET /b HTTP/1.1\r\n\r\n")); len(out) != 2 || !bytes.HasPrefix(out[0], []byte("1 b")) || !bytes.HasPrefix(out[1], []byte("2 b 600000000\nHTTP/1.1 200")) {
+		t.Errorf("Slow response should be emitted with request first: %q", out)
+	}
+
+	f.Filter([]byte("1 c 1\nGET /c HTTP/1.1\r\n\r\n"))
+	if out := f.Filter([]byte("2 c 1000000\nHTTP/1.1 200 OK\r\n\r\n")); len(out) != 0 {
+		t.Error("Fast successful response should be dropped with its request")
+	}
+
+	if out := f.Filter([]byte("3 c 1\nHTTP/1.1 200 OK\r\n\r\n")); len(out) != 1 {
+		t.Error("Replayed responses should pass without replayed filter")
+	}
+
+	if f.Len() != 0 {
+		t.Error("Decided pairs should not be kept", f.Len())
+	}
+}
+
+func TestResponseFilterReplayed(t *testing.T) {
+	f := newTestResponseFilter(t, "", `status != 200`, time.Minute)
+
+	if out := f.Filter([]byte("1 a 1\nGET / HTTP/1.1\r\n\r\n")); len(out) != 1 {
+		t.Error("Requests should not be held without original response filter")
+	}
+
+	if out := f.Filter([]byte("3 a 1\nHTTP/1.1 200 OK\r\n\r\n")); len(out) != 0 {
+		t.Error("Replayed response should be dropped")
+	}
+
+	if out := f.Filter([]byte("3 a 1\nHTTP/1.1 502 Bad Gateway\r\n\r\n")); len(out) != 1 {
+		t.Error("Replayed response should pass")
+	}
+}
+
+func TestResponseFilterWindow(t *testing.T) {
+	f := newTestResponseFilter(t, `status >= 500`, "", 10*time.Millisecond)
+
+	f.Filter([]byte("1 a 1\nGET / HTTP/1.1\r\n\r\n"))
+	time.Sleep(20 * time.Millisecond)
+
+	if out := f.Filter([]byte("2 a 1\nHTTP/1.1 500 Internal Server Error\r\n\r\n")); len(out) != 0 {
+		t.Error("Request without response during window should be dropped")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	f.Filter([]byte("1 b 1\nGET / HTTP/1.1\r\n\r\n"))
+
+	if f.Len() != 1 {
+		t.Error("Expired payloads should be removed", f.Len())
+	}
+}
+
+// testPayloadReader returns one payload per Read call, reusing caller buffer
+type testPayloadReader struct {
+	payloads []string
+}
+
+func (r *testPayloadReader) Read(data []byte) (int, error) {
+	if len(r.payloads) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(data, r.payloads[0])
+	r.payloads = r.payloads[1:]
+
+	return n, nil
+}
+
+func TestEmitterResponseFilter(t *testing.T) {
+	ResponseFilters = newTestResponseFilter(t, `status >= 500`, "", time.Minute)
+	defer func() { ResponseFilters = nil }()
+
+	var received []string
+	output := NewTestOutput(func(data []byte) {
+		received = append(received, string(data))
+	})
+
+	CopyMulty(&testPayloadReader{[]string{
+		"1 a 1\nGET /a HTTP/1.1\r\n\r\n",
+		"1 b 1\nGET /b HTTP/1.1\r\n\r\n",
+		"2 b 1\nHTTP/1.1 200 OK\r\n\r\n",
+		"2 a 1\nHTTP/1.1 503 Service Unavailable\r\n\r\n",
+	}}, output)
+
+	if len(received) != 2 || received[0] != "1 a 1\nGET /a HTTP/1.1\r\n\r\n" || received[1] != "2 a 1\nHTTP/1.1 503 Service Unavailable\r\n\r\n" {
+		t.Errorf("Only failed transaction should be emitted: %q", received)
+	}
+}
+
+func TestResponseFilterOriginalStatus(t *testing.T) {
+	Correlations = NewCorrelationStore(&CorrelationConfig{ttl: time.Minute})
+	defer func() { Correlations = nil }()
+
+	f := newTestResponseFilter(t, "", `status >= 500 and original_status < 500`, time.Minute)
+
+	Correlations.Add([]byte("2 a 1\nHTTP/1.1 200 OK\r\n\r\n"))
+	Correlations.Add([]byte("2 b 1\nHTTP/1.1 503 Service Unavailable\r\n\r\n"))
+
+	if out := f.Filter([]byte("3 a 1\nHTTP/1.1 500 Internal Server Error\r\n\r\n")); len(out) != 1 {
+		t.Error("Replayed failure of successful original request should pass")
+	}
+
+	if out := f.Filter([]byte("3 b 1\nHTTP/1.1 500 Internal Server Error\r\n\r\n")); len(out) != 0 {
+		t.Error("Replayed failure of failed original request should be dropped")
+	}
+
+	if out := f.Filter([]byte("3 c 1\nHTTP/1.1 500 Internal Server Error\r\n\r\n")); len(out) != 0 {
+		t.Error("Replayed response without original should be dropped")
+	}
+}
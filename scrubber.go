@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/buger/gor/proto"
+)
+
+// ScrubberConfig holds PII scrubbing rules
+type ScrubberConfig struct {
+	mask MultiOption
+	hash MultiOption
+	// Secret mixed into hashes, so hashed values can't be guessed by brute force
+	salt string
+}
+
+const (
+	scrubMask = "mask"
+	scrubHash = "hash"
+)
+
+// Number of hex characters kept from hash
+const scrubHashLength = 16
+
+type scrubDetector struct {
+	re *regexp.Regexp
+	// Optional check to reduce false positives
+	valid func([]byte) bool
+}
+
+// Built-in detectors of personal data in any part of payload
+var scrubDetectors = map[string]scrubDetector{
+	"email": {re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)},
+	"card":  {re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhnValid},
+	// International numbers with `+`, or numbers with separators: (555) 123-4567, 555-123-4567
+	"phone": {re: regexp.MustCompile(`\+\d{1,3}[ .\-]?(?:\(\d{1,4}\)|\d{1,4})(?:[ .\-]?\d{2,4}){2,3}\b|\(\d{3}\) ?\d{3}[ .\-]\d{4}\b|\b\d{3}[.\-]\d{3}[.\-]\d{4}\b`)},
+}
+
+type scrubRule struct {
+	action string
+	// header, cookie, param, json, form or detect
+	kind     string
+	name     []byte
+	path     []string
+	detector scrubDetector
+}
+
+// Scrubber masks or hashes personal data (headers, URL params, body fields, and values found by detectors)
+// in all payloads before they reach outputs, so raw production data is not stored or sent anywhere.
+//
+// Hashing is deterministic: same value always gets same hash, so replayed sessions and aliases keep working.
+type Scrubber struct {
+	rules []scrubRule
+	salt  []byte
+}
+
+// NewScrubber constructor for Scrubber, returns nil if no rules configured
+func NewScrubber(config *ScrubberConfig) *Scrubber {
+	if len(config.mask) == 0 && len(config.hash) == 0 {
+		return nil
+	}
+
+	s := &Scrubber{salt: []byte(config.salt)}
+
+	s.addRules(scrubMask, config.mask)
+	s.addRules(scrubHash, config.hash)
+
+	return s
+}
+
+func (s *Scrubber) addRules(action string, targets []string) {
+	for _, target := range targets {
+		rule, err := parseScrubRule(action, target)
+		if err != nil {
+			log.Fatal("Cannot parse scrubbing rule: ", err)
+		}
+
+		s.rules = append(s.rules, rule)
+	}
+}
+
+func parseScrubRule(action, target string) (rule scrubRule, err error) {
+	rule.action = action
+
+	kv := strings.SplitN(target, ":", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return rule, fmt.Errorf("target should be `header:<name>`, `cookie:<name>`, `param:<name>`, `json:<path>`, `form:<name>` or `detect:<email|card|phone>`, got: %s", target)
+	}
+
+	rule.kind = kv[0]
+
+	switch rule.kind {
+	case "header", "cookie", "param", "form":
+		rule.name = []byte(kv[1])
+	case "json":
+		rule.path = strings.Split(kv[1], ".")
+	case "detect":
+		d, ok := scrubDetectors[kv[1]]
+		if !ok {
+			return rule, fmt.Errorf("unknown detector: %s", kv[1])
+		}
+		rule.detector = d
+	default:
+		err = fmt.Errorf("unknown target type: %s", rule.kind)
+	}
+
+	return
+}
+
+// Scrub applies all rules to request or response payload, returns modified payload
+func (s *Scrubber) Scrub(payload []byte) []byte {
+	headSize := bytes.IndexByte(payload, '\n') + 1
+	data := payload[headSize:]
+
+	if !(proto.IsHTTPPayload(data) || bytes.HasPrefix(data, []byte("HTTP/"))) || proto.MIMEHeadersEndPos(data) == -1 {
+		return payload
+	}
+
+	original := data
+	data = append([]byte(nil), data...)
+
+	for _, rule := range s.rules {
+		data = s.apply(rule, data)
+	}
+
+	if bytes.Equal(data, original) {
+		return payload
+	}
+
+	return append(append([]byte(nil), payload[:headSize]...), data...)
+}
+
+func (s *Scrubber) apply(rule scrubRule, data []byte) []byte {
+	switch rule.kind {
+	case "header":
+		msg := proto.NewMessage(data)
+		msg.UpdateHeaders(rule.name, func(value []byte) []byte {
+			if len(value) == 0 {
+				return value
+			}
+
+			return s.replace(rule.action, value)
+		})
+
+		return msg.Bytes()
+	case "cookie":
+		msg := proto.NewMessage(data)
+
+		if bytes.HasPrefix(data, []byte("HTTP/")) {
+			// Set-Cookie holds single cookie, followed by its attributes
+			msg.UpdateHeaders([]byte("Set-Cookie"), func(value []byte) []byte {
+				pair := value
+				if end := bytes.IndexByte(value, ';'); end != -1 {
+					pair = value[:end]
+				}
+
+				return s.replaceCookie(rule, value, pair)
+			})
+		} else {
+			msg.UpdateHeaders([]byte("Cookie"), func(value []byte) []byte {
+				return s.replaceCookie(rule, value, value)
+			})
+		}
+
+		return msg.Bytes()
+	case "param":
+		if !proto.IsHTTPPayload(data) {
+			return data
+		}
+
+		if value, start, _ := proto.PathParam(data, rule.name); start != -1 && len(value) > 0 {
+			return proto.SetPathParam(data, rule.name, s.replace(rule.action, value))
+		}
+	case "json":
+		return s.scrubBody(data, func(body []byte) []byte {
+			members := jsonMembers(body, rule.path)
+			if len(members) == 0 {
+				return body
+			}
+
+			out := append([]byte(nil), body...)
+			for i := len(members) - 1; i >= 0; i-- {
+				m := members[i]
+				value := s.replaceJSON(rule.action, out[m.valueStart:m.valueEnd])
+				out = append(out[:m.valueStart], append(value, out[m.valueEnd:]...)...)
+			}
+
+			return out
+		})
+	case "form":
+		if boundary := proto.MultipartBoundary(data); boundary != nil {
+			return s.scrubBody(data, func(body []byte) []byte {
+				return s.replaceMultipart(rule, body, boundary)
+			})
+		}
+
+		if !bytes.HasPrefix(proto.Header(data, []byte("Content-Type")), []byte("application/x-www-form-urlencoded")) {
+			return data
+		}
+
+		return s.scrubBody(data, func(body []byte) []byte {
+			return s.replaceForm(rule, body)
+		})
+	case "detect":
+		headersEnd := proto.MIMEHeadersEndPos(data) + 4
+		data = append(s.detect(rule, data[:headersEnd]), data[headersEnd:]...)
+
+		return s.scrubBody(data, func(body []byte) []byte {
+			return s.detect(rule, body)
+		})
+	}
+
+	return data
+}
+
+// scrubBody replaces payload body with scrubbed one. Compressed bodies are decoded, and encoded back after scrubbing.
+// Chunked bodies can't be re-framed, so they are changed only if scrubbing keeps body length, like masking does.
+func (s *Scrubber) scrubBody(data []byte, scrub func(body []byte) []byte) []byte {
+	msg := proto.NewMessage(data)
+	body := msg.Body()
+
+	if len(body) == 0 {
+		return data
+	}
+
+	if msg.IsEncoded() {
+		decoded, err := msg.DecodeBody()
+		if err != nil {
+			Debug("[SCRUBBER] Can't decode body, skipping:", err)
+			return data
+		}
+
+		if scrubbed := scrub(decoded); !bytes.Equal(scrubbed, decoded) {
+			if err := msg.EncodeBody(scrubbed); err != nil {
+				Debug("[SCRUBBER] Can't encode body, skipping:", err)
+				return data
+			}
+		}
+
+		return msg.Bytes()
+	}
+
+	scrubbed := scrub(body)
+	if bytes.Equal(scrubbed, body) {
+		return data
+	}
+
+	if msg.IsChunked() && len(scrubbed) != len(body) {
+		Debug("[SCRUBBER] Can't change length of chunked body, skipping")
+		return data
+	}
+
+	msg.SetBody(scrubbed)
+
+	return msg.Bytes()
+}
+
+// detect replaces all values found by detector
+func (s *Scrubber) detect(rule scrubRule, data []byte) []byte {
+	return rule.detector.re.ReplaceAllFunc(data, func(value []byte) []byte {
+		if rule.detector.valid != nil && !rule.detector.valid(value) {
+			return value
+		}
+
+		return s.replace(rule.action, value)
+	})
+}
+
+// replaceCookie scrubs value of named cookie found in cookies, which is prefix of header value
+func (s *Scrubber) replaceCookie(rule scrubRule, header, cookies []byte) []byte {
+	value, start, end := cookieValue(cookies, rule.name)
+	if len(value) == 0 {
+		return header
+	}
+
+	return append(append(append([]byte(nil), header[:start]...), s.replace(rule.action, value)...), header[end:]...)
+}
+
+func (s *Scrubber) replaceForm(rule scrubRule, body []byte) []byte {
+	fields := bytes.Split(body, []byte("&"))
+
+	for i, field := range fields {
+		kv := bytes.SplitN(field, []byte("="), 2)
+		if len(kv) != 2 || len(kv[1]) == 0 {
+			continue
+		}
+
+		if key, err := url.QueryUnescape(string(kv[0])); err == nil && key == string(rule.name) {
+			fields[i] = append(append(append([]byte(nil), kv[0]...), '='), s.replace(rule.action, kv[1])...)
+		}
+	}
+
+	return bytes.Join(fields, []byte("&"))
+}
+
+// replaceMultipart scrubs `multipart/form-data` fields, file parts are not touched
+func (s *Scrubber) replaceMultipart(rule scrubRule, body, boundary []byte) []byte {
+	multipart, err := proto.ParseMultipart(body, boundary)
+	if err != nil {
+		return body
+	}
+
+	changed := false
+
+	for i := range multipart.Parts {
+		p := &multipart.Parts[i]
+		if len(p.Body) > 0 && !p.IsFile() && bytes.Equal(p.Name(), rule.name) {
+			p.Body, changed = s.replace(rule.action, p.Body), true
+		}
+	}
+
+	if !changed {
+		return body
+	}
+
+	return multipart.Bytes()
+}
+
+// replaceJSON scrubs JSON value: content of strings, other values replaced by string
+func (s *Scrubber) replaceJSON(action string, value []byte) []byte {
+	if len(value) >= 2 && value[0] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	return append(append([]byte{'"'}, s.replace(action, value)...), '"')
+}
+
+// replace returns masked value of the same length, or hash of the value
+func (s *Scrubber) replace(action string, value []byte) []byte {
+	if action == scrubMask {
+		return bytes.Repeat([]byte("*"), len(value))
+	}
+
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write(value)
+
+	return []byte(hex.EncodeToString(mac.Sum(nil))[:scrubHashLength])
+}
+
+func (s *Scrubber) String() string {
+	return fmt.Sprintf("Scrubber with %d rules", len(s.rules))
+}
+
+// luhnValid checks credit card number checksum, ignoring separators
+func luhnValid(number []byte) bool {
+	sum, digits := 0, 0
+
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		digits++
+	}
+
+	return digits >= 13 && sum%10 == 0
+}
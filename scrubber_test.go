@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/buger/gor/proto"
+)
+
+func TestScrubber(t *testing.T) {
+	s := NewScrubber(&ScrubberConfig{
+		mask: MultiOption{"header:Authorization", "cookie:sid", "json:user.card", "detect:card"},
+		hash: MultiOption{"param:email", "json:user.email", "form:phone", "detect:email"},
+		salt: "secret",
+	})
+
+	payload := []byte("1 a 1\nPOST /?email=a&x=1 HTTP/1.1\r\nAuthorization: Bearer abc\r\nCookie: a=1; sid=123\r\nContent-Length: 70\r\n\r\n{\"user\": {\"email\": \"a\", \"card\": 4111111111111111}, \"note\": \"b@c.com\"}")
+	scrubbed := s.Scrub(payload)
+	body := payloadBody(scrubbed)
+
+	hash := string(s.replace(scrubHash, []byte("a")))
+	expected := "{\"user\": {\"email\": \"" + hash + "\", \"card\": \"****************\"}, \"note\": \"" + string(s.replace(scrubHash, []byte("b@c.com"))) + "\"}"
+
+	if !bytes.HasPrefix(scrubbed, []byte("1 a 1\nPOST /?email="+hash+"&x=1 HTTP/1.1\r\n")) {
+		t.Errorf("Param should be hashed: %q", scrubbed)
+	}
+
+	if string(proto.Header(body, []byte("Authorization"))) != "**********" || string(proto.Header(body, []byte("Cookie"))) != "a=1; sid=***" {
+		t.Errorf("Headers should be masked: %q", body)
+	}
+
+	if string(proto.Body(body)) != expected {
+		t.Errorf("Expected body %q, got %q", expected, proto.Body(body))
+	}
+
+	if string(proto.Header(body, []byte("Content-Length"))) != strconv.Itoa(len(expected)) {
+		t.Error("Content-Length should be updated", string(proto.Header(body, []byte("Content-Length"))))
+	}
+
+	if !bytes.Equal(s.Scrub(payload), scrubbed) {
+		t.Error("Hashing should be deterministic")
+	}
+
+	form := s.Scrub([]byte("1 a 1\nPOST / HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\n\r\nphone=123&name=john"))
+	if !bytes.HasSuffix(form, []byte("\r\n\r\nphone="+string(s.replace(scrubHash, []byte("123")))+"&name=john")) {
+		t.Errorf("Form field should be hashed: %q", form)
+	}
+
+	multipart := s.Scrub([]byte("1 a 1\nPOST / HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=xyz\r\n\r\n--xyz\r\nContent-Disposition: form-data; name=\"phone\"\r\n\r\n123\r\n--xyz\r\nContent-Disposition: form-data; name=\"phone\"; filename=\"a.txt\"\r\n\r\n123\r\n--xyz--\r\n"))
+	if !bytes.Contains(multipart, []byte("name=\"phone\"\r\n\r\n"+string(s.replace(scrubHash, []byte("123")))+"\r\n")) || !bytes.Contains(multipart, []byte("a.txt\"\r\n\r\n123\r\n")) {
+		t.Errorf("Multipart field should be hashed, file should be kept: %q", multipart)
+	}
+
+	response := []byte("2 a 1\nHTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nx@y.com")
+	if bytes.Contains(s.Scrub(response), []byte("x@y.com")) {
+		t.Error("Responses should be scrubbed as well")
+	}
+}
+
+func TestScrubDetectors(t *testing.T) {
+	cases := []struct {
+		detector, text string
+		found          bool
+	}{
+		{"email", "contact: john.doe+tag@mail.example.org", true},
+		{"email", "no emails @ here", false},
+		{"card", "card 4111 1111 1111 1111 end", true},
+		{"card", "card 4111-1111-1111-1112 end", false},
+		{"card", "id 1234567890123456", false},
+		{"phone", "call +1 555 123 4567", true},
+		{"phone", "call (555) 123-4567", true},
+		{"phone", "call 555-123-4567", true},
+		{"phone", "timestamp 1441461600000", false},
+	}
+
+	for _, c := range cases {
+		s := &Scrubber{}
+		rule, _ := parseScrubRule(scrubMask, "detect:"+c.detector)
+
+		out := s.detect(rule, []byte(c.text))
+		if found := strings.Contains(string(out), "***"); found != c.found {
+			t.Errorf("%s in %q: expected %v, got %q", c.detector, c.text, c.found, out)
+		}
+	}
+}
+
+func TestScrubberRepeatedHeaders(t *testing.T) {
+	s := NewScrubber(&ScrubberConfig{
+		mask: MultiOption{"header:Authorization", "cookie:sid"},
+	})
+
+	request := s.Scrub([]byte("1 a 1\nGET / HTTP/1.1\r\nAuthorization: Bearer abc\r\nCookie: sid=123\r\nAuthorization: Basic xyz\r\nCookie: a=1; sid=456\r\n\r\n"))
+	if string(payloadBody(request)) != "GET / HTTP/1.1\r\nAuthorization: **********\r\nCookie: sid=***\r\nAuthorization: *********\r\nCookie: a=1; sid=***\r\n\r\n" {
+		t.Errorf("Every occurrence should be masked: %q", request)
+	}
+
+	for _, payloadType := range []string{"2", "3"} {
+		response := s.Scrub([]byte(payloadType + " a 1\nHTTP/1.1 200 OK\r\nSet-Cookie: sid=123; Path=/; HttpOnly\r\nSet-Cookie: a=1\r\nSet-Cookie: sid=45; Max-Age=10\r\n\r\n"))
+		if string(payloadBody(response)) != "HTTP/1.1 200 OK\r\nSet-Cookie: sid=***; Path=/; HttpOnly\r\nSet-Cookie: a=1\r\nSet-Cookie: sid=**; Max-Age=10\r\n\r\n" {
+			t.Errorf("Set-Cookie should be masked: %q", response)
+		}
+	}
+}
+
+func TestScrubberEncodedBody(t *testing.T) {
+	s := NewScrubber(&ScrubberConfig{
+		hash: MultiOption{"detect:email"},
+	})
+
+	chunked := []byte("2 a 1\nHTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n7\r\nx@y.com\r\n0\r\n\r\n")
+	if out := s.Scrub(chunked); !bytes.Equal(out, chunked) {
+		t.Errorf("Chunked body can't be hashed, as it changes chunk size: %q", out)
+	}
+
+	masked := NewScrubber(&ScrubberConfig{mask: MultiOption{"detect:email"}}).Scrub(chunked)
+	if string(payloadBody(masked)) != "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n7\r\n*******\r\n0\r\n\r\n" {
+		t.Errorf("Chunked body should be masked in place: %q", masked)
+	}
+
+	body, _ := proto.Encode([]byte("gzip"), []byte(`{"email":"x@y.com"}`))
+	gzipped := []byte("2 a 1\nHTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body))
+
+	out := payloadBody(s.Scrub(gzipped))
+	decoded, err := proto.DecodeBody(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded) != `{"email":"`+string(s.replace(scrubHash, []byte("x@y.com")))+`"}` {
+		t.Errorf("Compressed body should be scrubbed: %q", decoded)
+	}
+
+	if string(proto.Header(out, []byte("Content-Length"))) != strconv.Itoa(len(proto.Body(out))) {
+		t.Error("Content-Length should match encoded body")
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -23,6 +26,71 @@ func (h *MultiOption) Set(value string) error {
 	return nil
 }
 
+// DataSize allows to specify size in human readable format: 512, 100kb, 32mb, 1gb
+type DataSize int64
+
+var dataSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"gb", 1024 * 1024 * 1024},
+	{"mb", 1024 * 1024},
+	{"kb", 1024},
+	{"b", 1},
+}
+
+func (s *DataSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+// Set parses size value, if unit is not specified value treated as bytes
+func (s *DataSize) Set(value string) error {
+	value = strings.ToLower(strings.TrimSpace(value))
+	multiplier := int64(1)
+
+	for _, u := range dataSizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(value[:len(value)-len(u.suffix)])
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return errors.New("Expected size like 100kb, 32mb or 1gb")
+	}
+
+	*s = DataSize(n * multiplier)
+	return nil
+}
+
+// TimeValue allows to specify time either in RFC3339 format or as `2006-01-02 15:04:05` in local timezone
+type TimeValue struct {
+	time.Time
+}
+
+var timeValueLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+func (t *TimeValue) String() string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func (t *TimeValue) Set(value string) error {
+	for _, layout := range timeValueLayouts {
+		if parsed, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+
+	return errors.New("Expected time in RFC3339 format, or like '2015-09-05 14:00:00'")
+}
+
 // AppSettings is the struct of main configuration
 type AppSettings struct {
 	verbose bool
@@ -38,18 +106,35 @@ type AppSettings struct {
 	outputTCP      MultiOption
 	outputTCPStats bool
 
-	inputFile  MultiOption
-	outputFile MultiOption
+	inputFile        MultiOption
+	inputFileConfig  FileInputConfig
+	outputFile       MultiOption
+	outputFileConfig FileOutputConfig
 
 	inputRAW MultiOption
 
-	middleware string
+	middleware         MultiOption
+	middlewareConfig   MiddlewareConfig
+	middlewareProtocol string
+
+	correlationConfig CorrelationConfig
+
+	responseFilterConfig ResponseFilterConfig
+
+	processors       MultiOption
+	middlewareScript string
+	aliases          MultiOption
+
+	framing         string
+	framingChecksum bool
 
 	inputHTTP  MultiOption
 	outputHTTP MultiOption
 
 	outputHTTPConfig HTTPOutputConfig
 	modifierConfig   HTTPModifierConfig
+	customMethods    MultiOption
+	scrubberConfig   ScrubberConfig
 }
 
 // Settings holds Gor configuration
@@ -77,12 +162,38 @@ func init() {
 	flag.Var(&Settings.outputTCP, "output-tcp", "Used for internal communication between Gor instances. Example: \n\t# Listen for requests on 80 port and forward them to other Gor instance on 28020 port\n\tgor --input-raw :80 --output-tcp replay.local:28020")
 	flag.BoolVar(&Settings.outputTCPStats, "output-tcp-stats", false, "Report TCP output queue stats to console every 5 seconds.")
 
-	flag.Var(&Settings.inputFile, "input-file", "Read requests from file: \n\tgor --input-file ./requests.gor --output-http staging.com")
-	flag.Var(&Settings.outputFile, "output-file", "Write incoming requests to file: \n\tgor --input-raw :80 --output-file ./requests.gor")
+	flag.Var(&Settings.inputFile, "input-file", "Read requests from file, gzip and zstd compressed files are detected automatically: \n\tgor --input-file ./requests.gor --output-http staging.com")
+	flag.Var(&Settings.inputFileConfig.from, "input-file-from", "Replay only requests recorded after given time. Uses index file if it exists, so no need to read whole file:\n\tgor --input-file ./requests.gor --input-file-from '2015-09-05 14:00:00' --input-file-to '2015-09-05 14:05:00' --output-http staging.com")
+	flag.Var(&Settings.inputFileConfig.to, "input-file-to", "Replay only requests recorded before given time.")
+
+	flag.Var(&Settings.outputFile, "output-file", "Write incoming requests to file: \n\tgor --input-raw :80 --output-file ./requests.gor\n\tPath can contain placeholders which used when file get rotated: %Y, %m, %d, %H, %M, %S and %i (chunk index):\n\tgor --input-raw :80 --output-file ./requests-%Y%m%d-%H%M.gor --output-file-rotate-interval 1h")
+	flag.Var(&Settings.outputFileConfig.sizeLimit, "output-file-size-limit", "Close current file and start new one when it reaches given size: \n\tgor --input-raw :80 --output-file ./requests-%i.gor --output-file-size-limit 32mb")
+	flag.DurationVar(&Settings.outputFileConfig.rotateInterval, "output-file-rotate-interval", 0, "Close current file and start new one after given interval: \n\tgor --input-raw :80 --output-file ./requests-%H%M.gor --output-file-rotate-interval 5m")
+	flag.IntVar(&Settings.outputFileConfig.maxFiles, "output-file-max-files", 0, "Keep only given number of rotated files, oldest get removed. By default all files are kept.")
+	flag.StringVar(&Settings.outputFileConfig.compression, "output-file-compression", "", "Compress output file using `gzip` or `zstd`. By default detected by file extension (.gz or .zst):\n\tgor --input-raw :80 --output-file ./requests.gor.gz")
+	flag.DurationVar(&Settings.outputFileConfig.flushInterval, "output-file-flush-interval", time.Second, "Interval for flushing buffered data to the file.")
+	flag.BoolVar(&Settings.outputFileConfig.index, "output-file-index", false, "Write index file next to the recording, which allows to replay specific time range faster using --input-file-from and --input-file-to. Not supported for compressed files.")
+
+	flag.StringVar(&Settings.framing, "payload-framing", framingSeparator, "Format used for writing payloads to file and TCP outputs: `separator` (default, readable by older Gor versions) or `binary` (length-prefixed, safe for payloads with any bytes). Inputs detect format automatically.")
+	flag.BoolVar(&Settings.framingChecksum, "payload-checksum", false, "Add CRC32 checksum to each payload written with --payload-framing binary, inputs verify it automatically.")
 
 	flag.Var(&Settings.inputRAW, "input-raw", "Capture traffic from given port (use RAW sockets and require *sudo* access):\n\t# Capture traffic from 8080 port\n\tgor --input-raw :8080 --output-http staging.com")
 
-	flag.StringVar(&Settings.middleware, "middleware", "", "Used for modifying traffic using external command")
+	flag.Var(&Settings.middleware, "middleware", "Used for modifying traffic using external command. Can be specified multiple times, commands get chained in order:\n\tgor --input-raw :80 --middleware './auth.rb --env staging' --middleware './rewrite.py' --output-http staging.com")
+	flag.DurationVar(&Settings.middlewareConfig.timeout, "middleware-timeout", 0, "Maximum time to wait for middleware reply to each message. Disabled by default, should not be used if middleware filters messages by not replying to them. Example: --middleware-timeout 1s")
+	flag.IntVar(&Settings.middlewareConfig.workers, "middleware-workers", 1, "Number of processes started for each middleware command. Request and its responses always go to the same process.")
+	flag.StringVar(&Settings.middlewareConfig.sessionKey, "middleware-session-key", "", "Send all requests with the same session key value (and their responses) to the same middleware process. Can be `header:<name>`, `param:<name>` or `meta:<key>`:\n\tgor --input-raw :80 --middleware './modifier' --middleware-workers 4 --middleware-session-key 'header:X-Session-Id' --output-http staging.com")
+	flag.StringVar(&Settings.middlewareConfig.timeoutPolicy, "middleware-timeout-policy", middlewarePolicyDrop, "What to do with message if middleware did not reply in time, or is restarting: `drop` it or `pass` original message.")
+	flag.StringVar(&Settings.middlewareProtocol, "middleware-protocol", middlewareProtocolHex, "Format of messages exchanged with middleware: `hex` (hex encoded payloads) or `json` (parsed payloads, one JSON object per line):\n\tgor --input-raw :80 --middleware './modifier.py' --middleware-protocol json --output-http staging.com")
+
+	flag.StringVar(&Settings.middlewareScript, "middleware-script", "", "Modify traffic using Lua script, which runs inside Gor process:\n\tgor --input-raw :80 --middleware-script ./examples/middleware/token_modifier.lua --output-http staging.com")
+	flag.DurationVar(&Settings.correlationConfig.ttl, "correlation-ttl", 0, "Keep request, original and replayed responses together in memory for given time, so they can be accessed by middleware, Elasticsearch output and original_status filter operand. Disabled by default. Example: --correlation-ttl 1m")
+	Settings.correlationConfig.sizeLimit = 64 * 1024 * 1024
+	flag.Var(&Settings.correlationConfig.sizeLimit, "correlation-size-limit", "Maximum size of payloads kept by correlation store, oldest are evicted first.")
+
+	flag.Var(&Settings.aliases, "http-alias", "Learn aliases of values (like auth tokens or session ids) from original and replayed responses, and substitute them in following requests. Format: `<source> => <target>`, where source is `header:<name>`, `cookie:<name>`, `json:<path>`, `regexp:<expr>` or `body`, and target is `header:<name>`, `cookie:<name>`, `param:<name>` or `body`:\n\tgor --input-raw :80 --http-alias 'json:access_token => header:Authorization' --output-http staging.com")
+
+	flag.Var(&Settings.processors, "middleware-processor", "Process traffic using in-process middleware compiled into Gor binary, can be specified multiple times. Accepts processor name with optional options:\n\tgor --input-raw :80 --middleware-processor 'ratelimit:100' --output-http staging.com")
 
 	flag.Var(&Settings.inputHTTP, "input-http", "Read requests from HTTP, should be explicitly sent from your application:\n\t# Listen for http on 9000\n\tgor --input-http :9000 --output-http staging.com")
 
@@ -92,6 +203,8 @@ func init() {
 	flag.DurationVar(&Settings.outputHTTPConfig.Timeout, "output-http-timeout", 0, "Specify HTTP request/response timeout. By default 5s. Example: --output-http-timeout 30s")
 
 	flag.BoolVar(&Settings.outputHTTPConfig.stats, "output-http-stats", false, "Report http output queue stats to console every 5 seconds.")
+	flag.StringVar(&Settings.outputHTTPConfig.cookieSession, "output-http-cookie-session", "", "Keep cookies set by replayed server for each user session, and send them with following requests of the same session instead of original ones. Session identified by original `cookie:<name>`, `header:<name>` or client `ip`:\n\tgor --input-raw :80 --output-http staging.com --output-http-cookie-session 'cookie:JSESSIONID'")
+	flag.BoolVar(&Settings.outputHTTPConfig.cookieStrip, "output-http-cookie-strip", false, "Remove original cookies, which were not set by replayed server. Used with --output-http-cookie-session.")
 	flag.BoolVar(&Settings.outputHTTPConfig.OriginalHost, "http-original-host", false, "Normally gor replaces the Host http header with the host supplied with --output-http.  This option disables that behavior, preserving the original Host header.")
 
 	flag.StringVar(&Settings.outputHTTPConfig.elasticSearch, "output-http-elasticsearch", "", "Send request and response stats to ElasticSearch:\n\tgor --input-raw :8080 --output-http staging.com --output-http-elasticsearch 'es_host:api_port/index_name'")
@@ -99,28 +212,56 @@ func init() {
 	flag.Var(&Settings.modifierConfig.headers, "http-set-header", "Inject additional headers to http reqest:\n\tgor --input-raw :8080 --output-http staging.com --http-set-header 'User-Agent: Gor'")
 	flag.Var(&Settings.modifierConfig.headers, "output-http-header", "WARNING: `--output-http-header` DEPRECATED, use `--http-set-header` instead")
 
+	flag.Var(&Settings.modifierConfig.deleteHeaders, "http-delete-header", "Remove header from request, header name is case-insensitive:\n\tgor --input-raw :8080 --output-http staging.com --http-delete-header X-Forwarded-For")
+	flag.Var(&Settings.modifierConfig.renameHeaders, "http-rename-header", "Rename request header, header name is case-insensitive:\n\tgor --input-raw :8080 --output-http staging.com --http-rename-header 'Authorization:X-Original-Authorization'")
+
 	flag.Var(&Settings.modifierConfig.params, "http-set-param", "Set request url param, if param already exists it will be overwritten:\n\tgor --input-raw :8080 --output-http staging.com --http-set-param api_key=1")
 
+	flag.Var(&Settings.modifierConfig.jsonFields, "http-set-json", "Set JSON body field, if field not found it will be added. Path is dot separated, `*` matches any array element or object member. Value is used as is if it is valid JSON, otherwise as string:\n\tgor --input-raw :8080 --output-http staging.com --http-set-json 'users.*.email=test@example.com'")
+	flag.Var(&Settings.modifierConfig.jsonDelete, "http-delete-json", "Delete JSON body field:\n\tgor --input-raw :8080 --output-http staging.com --http-delete-json user.password")
+	flag.Var(&Settings.modifierConfig.formFields, "http-set-form", "Set `application/x-www-form-urlencoded` body field, if field not found it will be added:\n\tgor --input-raw :8080 --output-http staging.com --http-set-form user_id=1")
+	flag.Var(&Settings.modifierConfig.bodyRewrite, "http-rewrite-body", "Replace body parts matching regexp:\n\tgor --input-raw :8080 --output-http staging.com --http-rewrite-body '[a-z]+@example\\.com:user@test.com'")
+	flag.Var(&Settings.modifierConfig.replaceFiles, "http-replace-files", "Replace content of multipart/form-data files of given size or larger with placeholder of the same size, so original files are not sent:\n\tgor --input-raw :8080 --output-http staging.com --http-replace-files 10kb")
+	flag.BoolVar(&Settings.modifierConfig.decodeBody, "http-decode-body", false, "Apply body modifiers to decoded content of compressed bodies (gzip, deflate, br), modified body is compressed back using same Content-Encoding:\n\tgor --input-raw :8080 --output-http staging.com --http-decode-body --http-delete-json user.password")
+
+	flag.Var(&Settings.customMethods, "http-custom-method", "Recognize custom HTTP method, so requests using it get replayed. By default standard methods, PATCH and WebDAV methods recognized. Use `*` to accept any method:\n\tgor --input-raw :8080 --output-http staging.com --http-custom-method PURGE")
+
 	flag.Var(&Settings.modifierConfig.methods, "http-allow-method", "Whitelist of HTTP methods to replay. Anything else will be dropped:\n\tgor --input-raw :8080 --output-http staging.com --http-allow-method GET --http-allow-method OPTIONS")
 	flag.Var(&Settings.modifierConfig.methods, "output-http-method", "WARNING: `--output-http-method` DEPRECATED, use `--http-allow-method` instead")
 
-	flag.Var(&Settings.modifierConfig.urlRegexp, "http-allow-url", "A regexp to match requests against. Filter get matched against full url with domain. Anything else will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-url ^www.")
+	flag.Var(&Settings.modifierConfig.filters, "http-filter", "Filter requests using boolean expression, requests not matching it get dropped. Supports and, or, not operators, grouping, and conditions on method, path, host, body, header(name), param(name), cookie(name), json(path) and hash(operand), see README for details:\n\t gor --input-raw :8080 --output-http staging.com --http-filter 'method in (GET, HEAD) and (path ~ \"^/api\" or header(\"X-Beta\") == 1)'")
+	flag.Var(&Settings.responseFilterConfig.filters, "http-response-filter", "Filter requests by their original response, using same expressions as --http-filter. Requests are held until response is seen, and then both passed or dropped. Operands status and latency (round-trip time in ms) available:\n\t gor --input-raw :8080 --output-file requests.gor --http-response-filter 'status >= 500 or latency > 500'")
+	flag.Var(&Settings.responseFilterConfig.replayedFilters, "http-replayed-response-filter", "Drop replayed responses not matching expression, see --http-filter:\n\t gor --input-raw :8080 --output-http staging.com --output-file responses.gor --http-replayed-response-filter 'status >= 500'")
+	flag.DurationVar(&Settings.responseFilterConfig.window, "http-response-filter-window", 5*time.Second, "How long request waits for its response when --http-response-filter used, requests without response are dropped")
+	flag.Var(&Settings.modifierConfig.urlRegexp, "http-allow-url", "A regexp to match requests against. Filter get matched against full url with domain (host/path?query, without scheme) and against path. Anything else will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-url ^www.")
 	flag.Var(&Settings.modifierConfig.urlRegexp, "output-http-url-regexp", "WARNING: `--output-http-url-regexp` DEPRECATED, use `--http-allow-url` instead")
 
-	flag.Var(&Settings.modifierConfig.urlNegativeRegexp, "http-disallow-url", "A regexp to match requests against. Filter get matched against full url with domain. Anything else will be forwarded:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-url ^www.")
+	flag.Var(&Settings.modifierConfig.urlNegativeRegexp, "http-disallow-url", "A regexp to match requests against. Filter get matched against full url with domain (host/path?query, without scheme) and against path. Anything else will be forwarded:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-url ^www.")
+
+	flag.Var(&Settings.modifierConfig.hostRegexp, "http-allow-host", "A regexp to match request host against, port is not included. Host taken from absolute url or Host header. Requests to other hosts will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-host '^api\\.example\\.com$'")
+	flag.Var(&Settings.modifierConfig.hostNegativeRegexp, "http-disallow-host", "A regexp to match request host against, port is not included. Requests to matching hosts will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-host 'internal'")
 
 	flag.Var(&Settings.modifierConfig.urlRewrite, "http-rewrite-url", "Rewrite the request url based on a mapping:\n\tgor --input-raw :8080 --output-http staging.com --http-rewrite-url /v1/user/([^\\/]+)/ping:/v2/user/$1/ping")
 	flag.Var(&Settings.modifierConfig.urlRewrite, "output-http-rewrite-url", "WARNING: `--output-http-rewrite-url` DEPRECATED, use `--http-rewrite-url` instead")
 
-	flag.Var(&Settings.modifierConfig.headerFilters, "http-allow-header", "A regexp to match a specific header against. Requests with non-matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-header api-version:^v1")
+	flag.Var(&Settings.modifierConfig.headerFilters, "http-allow-header", "A regexp to match a specific header against. Requests without header or with non-matching header will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-header api-version:^v1")
 	flag.Var(&Settings.modifierConfig.headerFilters, "output-http-header-filter", "WARNING: `--output-http-header-filter` DEPRECATED, use `--http-allow-header` instead")
 
-	flag.Var(&Settings.modifierConfig.headerFilters, "http-disallow-header", "A regexp to match a specific header against. Requests with matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-header \"User-Agent: Replayed by Gor\"")
+	flag.Var(&Settings.modifierConfig.headerOptionalFilters, "http-allow-header-if-present", "A regexp to match a specific header against, if request has it. Requests with non-matching header will be dropped, requests without header pass:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-header-if-present api-version:^v1")
+
+	flag.Var(&Settings.modifierConfig.headerNegativeFilters, "http-disallow-header", "A regexp to match a specific header against. Requests with matching headers will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-header \"User-Agent: Replayed by Gor\"")
+
+	flag.Var(&Settings.modifierConfig.metaFilters, "http-allow-meta", "A regexp to match payload meta field (src, dst, conn, seq, input) against. Requests without field or with non-matching value will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-allow-meta src:^10.0.0.")
+	flag.Var(&Settings.modifierConfig.metaNegativeFilters, "http-disallow-meta", "A regexp to match payload meta field (src, dst, conn, seq, input) against. Requests with matching value will be dropped:\n\t gor --input-raw :8080 --output-http staging.com --http-disallow-meta input:^http$")
 
 	flag.Var(&Settings.modifierConfig.headerHashFilters, "http-header-limiter", "Takes a fraction of requests, consistently taking or rejecting a request based on the FNV32-1A hash of a specific header:\n\t gor --input-raw :8080 --output-http staging.com --http-header-imiter user-id:25%")
 	flag.Var(&Settings.modifierConfig.headerHashFilters, "output-http-header-hash-filter", "WARNING: `output-http-header-hash-filter` DEPRECATED, use `--http-header-hash-limiter` instead")
 
 	flag.Var(&Settings.modifierConfig.paramHashFilters, "http-param-limiter", "Takes a fraction of requests, consistently taking or rejecting a request based on the FNV32-1A hash of a specific GET param:\n\t gor --input-raw :8080 --output-http staging.com --http-param-limiter user_id:25%")
+
+	flag.Var(&Settings.scrubberConfig.mask, "scrub-mask", "Replace personal data with `*` before it reaches any output. Accepts `header:<name>`, `cookie:<name>`, `param:<name>`, `json:<path>`, `form:<name>` or built-in detector `detect:<email|card|phone>`:\n\tgor --input-raw :80 --output-file requests.gor --scrub-mask header:Authorization --scrub-mask detect:card")
+	flag.Var(&Settings.scrubberConfig.hash, "scrub-hash", "Replace personal data with its hash before it reaches any output. Same value always gets same hash. Accepts same targets as --scrub-mask:\n\tgor --input-raw :80 --output-file requests.gor --scrub-hash json:user.email --scrub-hash detect:email")
+	flag.StringVar(&Settings.scrubberConfig.salt, "scrub-salt", "", "Secret used for --scrub-hash, so hashed values can't be guessed. Should be the same for all Gor instances, which data needs to be correlated.")
 }
 
 var previousDebugTime int64
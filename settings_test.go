@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestDataSize(t *testing.T) {
+	cases := map[string]DataSize{
+		"100":   100,
+		"100b":  100,
+		"10kb":  10 * 1024,
+		"32mb":  32 * 1024 * 1024,
+		"1GB":   1024 * 1024 * 1024,
+		" 2 mb": 2 * 1024 * 1024,
+	}
+
+	for value, expected := range cases {
+		var s DataSize
+
+		if err := s.Set(value); err != nil {
+			t.Error("Should parse", value, err)
+		}
+
+		if s != expected {
+			t.Error("Wrong size for", value, s)
+		}
+	}
+
+	var s DataSize
+	if err := s.Set("10tb"); err == nil {
+		t.Error("Should error on unknown unit")
+	}
+}
+
+func TestHeaderFilterFlags(t *testing.T) {
+	defer func() {
+		Settings.modifierConfig.headerFilters = nil
+		Settings.modifierConfig.headerOptionalFilters = nil
+		Settings.modifierConfig.headerNegativeFilters = nil
+	}()
+
+	flag.Set("http-allow-header", "api-version:^v1")
+	flag.Set("output-http-header-filter", "api-version:^v2")
+	flag.Set("http-allow-header-if-present", "X-Beta:^1$")
+	flag.Set("http-disallow-header", "User-Agent: Replayed by Gor")
+
+	config := Settings.modifierConfig
+	if len(config.headerFilters) != 2 || len(config.headerOptionalFilters) != 1 || len(config.headerNegativeFilters) != 1 {
+		t.Errorf("Header filters registered into wrong lists: %d %d %d", len(config.headerFilters), len(config.headerOptionalFilters), len(config.headerNegativeFilters))
+	}
+
+	if f := config.headerNegativeFilters[0]; string(f.name) != "User-Agent" || f.regexp.String() != "Replayed by Gor" {
+		t.Errorf("Wrong disallow filter: %q %q", f.name, f.regexp)
+	}
+}
+
+func TestPayloadFramingDefault(t *testing.T) {
+	// Binary framing can't be read by older Gor versions, so it is opt-in
+	if f := flag.Lookup("payload-framing"); f.DefValue != framingSeparator {
+		t.Error("Legacy separator framing should be default:", f.DefValue)
+	}
+}
+
+func TestOutputFileIndexDefault(t *testing.T) {
+	if f := flag.Lookup("output-file-index"); f.DefValue != "false" {
+		t.Error("Index file should be opt-in:", f.DefValue)
+	}
+}